@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/function61/deployer/pkg/artefactcache"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+const (
+	artefactCacheDefaultMaxSize = "10G"
+)
+
+// optionally implemented by artefactDownloader if it knows content digests beforehand,
+// so the cache can be keyed by content instead of location
+type artefactDigester interface {
+	ArtefactDigest(filename string) (string, bool)
+}
+
+// implemented by artefactDownloader that keeps downloaded content around
+type artefactEvicter interface {
+	EvictArtefact(filename string) error
+}
+
+// all artefact downloads go through the cache, so deploying the same release to
+// multiple services downloads it only once
+type cachingArtefactDownloader struct {
+	artefactsLocation string
+	inner             artefactDownloader
+	cache             *artefactcache.Cache
+	maxSize           int64
+}
+
+func withArtefactCache(artefactsLocation string, inner artefactDownloader) (artefactDownloader, error) {
+	// local files are already local, and caching them would hide changes when developing
+	if strings.HasPrefix(artefactsLocation, "file:") {
+		return inner, nil
+	}
+
+	cache, maxSize, err := openArtefactCache()
+	if err != nil {
+		return nil, err
+	}
+
+	return &cachingArtefactDownloader{artefactsLocation, inner, cache, maxSize}, nil
+}
+
+func (c *cachingArtefactDownloader) DownloadArtefact(ctx context.Context, filename string) (io.ReadCloser, error) {
+	key := c.key(filename)
+
+	// so a concurrent download's eviction can't remove the entry before we open it
+	unpin := c.cache.Pin(key)
+	defer unpin()
+
+	cachedPath, found, err := c.cache.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		log.Printf("  using cached %s", filename)
+	} else {
+		origin := artefactcache.Origin{
+			ArtefactsLocation: c.artefactsLocation,
+			Filename:          filename,
+		}
+
+		cachedPath, err = func() (string, error) {
+			if fileDownloader, ok := c.inner.(artefactFileDownloader); ok {
+				return c.cache.PutWith(key, origin, func(destination string) error {
+					return fileDownloader.DownloadArtefactToFile(ctx, filename, destination, nil)
+				})
+			}
+
+			content, err := c.inner.DownloadArtefact(ctx, filename)
+			if err != nil {
+				return "", err
+			}
+			defer content.Close()
+
+			return c.cache.Put(key, origin, content)
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("cache %s: %w", filename, err)
+		}
+	}
+
+	// open before eviction. even if other process evicts it, the open file stays readable
+	content, err := os.Open(cachedPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		if _, err := c.cache.EvictToSize(c.maxSize); err != nil {
+			content.Close()
+			return nil, fmt.Errorf("cache eviction: %w", err)
+		}
+	}
+
+	return content, nil
+}
+
+// content failed verification, so it must not be served from cache again
+func (c *cachingArtefactDownloader) EvictArtefact(filename string) error {
+	if err := c.cache.Remove(c.key(filename)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (c *cachingArtefactDownloader) key(filename string) string {
+	if digester, ok := c.inner.(artefactDigester); ok {
+		if digest, found := digester.ArtefactDigest(filename); found {
+			return artefactcache.KeyForDigest(digest)
+		}
+	}
+
+	return artefactcache.KeyForLocation(c.artefactsLocation, filename)
+}
+
+func openArtefactCache() (*artefactcache.Cache, int64, error) {
+	dir := os.Getenv("DEPLOYER_CACHE_DIR")
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, 0, err
+		}
+
+		dir = filepath.Join(userCacheDir, "deployer", "artefacts")
+	}
+
+	maxSizeSerialized := os.Getenv("DEPLOYER_CACHE_MAX_SIZE")
+	if maxSizeSerialized == "" {
+		maxSizeSerialized = artefactCacheDefaultMaxSize
+	}
+
+	maxSize, err := artefactcache.ParseSize(maxSizeSerialized)
+	if err != nil {
+		return nil, 0, fmt.Errorf("DEPLOYER_CACHE_MAX_SIZE: %w", err)
+	}
+
+	cache, err := artefactcache.New(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return cache, maxSize, nil
+}
+
+func cacheEntry() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Subcommands for the local artefact cache",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls",
+		Short: "List cached artefacts",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(cacheList())
+		},
+	})
+
+	maxSize := ""
+	all := false
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Evict least recently used artefacts until cache fits in given size",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(cachePrune(maxSize, all))
+		},
+	}
+	pruneCmd.Flags().StringVarP(&maxSize, "max-size", "", maxSize, "Max size of cache, like 500M or 5G (default: $DEPLOYER_CACHE_MAX_SIZE or "+artefactCacheDefaultMaxSize+")")
+	pruneCmd.Flags().BoolVarP(&all, "all", "", all, "Remove everything")
+
+	cmd.AddCommand(pruneCmd)
+
+	return cmd
+}
+
+func cacheList() error {
+	cache, _, err := openArtefactCache()
+	if err != nil {
+		return err
+	}
+
+	entries, err := cache.List()
+	if err != nil {
+		return err
+	}
+
+	entriesTbl := termtables.CreateTable()
+	entriesTbl.AddHeaders("Last used", "Size", "Filename", "Artefact location", "Key")
+
+	total := int64(0)
+
+	for _, entry := range entries {
+		total += entry.Size
+
+		filename := entry.Origin.Filename
+		if entry.Partial {
+			filename += " (incomplete)"
+		}
+
+		entriesTbl.AddRow(
+			entry.LastUsed.Local().Format("Jan 02 @ 15:04"),
+			humanizeBytes(entry.Size),
+			filename,
+			entry.Origin.ArtefactsLocation,
+			entry.Key)
+	}
+
+	fmt.Println(entriesTbl.Render())
+	fmt.Printf("Total: %s in %d entries\n", humanizeBytes(total), len(entries))
+
+	return nil
+}
+
+func cachePrune(maxSizeSerialized string, all bool) error {
+	cache, maxSize, err := openArtefactCache()
+	if err != nil {
+		return err
+	}
+
+	if maxSizeSerialized != "" {
+		maxSize, err = artefactcache.ParseSize(maxSizeSerialized)
+		if err != nil {
+			return err
+		}
+	}
+
+	if all {
+		maxSize = 0
+	}
+
+	evicted, err := cache.EvictToSize(maxSize)
+	if err != nil {
+		return err
+	}
+
+	freed := int64(0)
+	for _, entry := range evicted {
+		freed += entry.Size
+	}
+
+	fmt.Printf("Evicted %d entries (%s)\n", len(evicted), humanizeBytes(freed))
+
+	return nil
+}
+
+func humanizeBytes(bytes int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%d %s", bytes, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %s", value, units[unit])
+}
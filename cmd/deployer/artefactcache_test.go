@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/function61/deployer/pkg/artefactcache"
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/gokit/assert"
+)
+
+// counts downloads so we know whether cache was used
+type fakeArtefactDownloader struct {
+	content   map[string]string
+	downloads int
+}
+
+func (f *fakeArtefactDownloader) DownloadArtefact(_ context.Context, filename string) (io.ReadCloser, error) {
+	f.downloads++
+	return ioutil.NopCloser(strings.NewReader(f.content[filename])), nil
+}
+
+func newTestCachingDownloader(t *testing.T, inner artefactDownloader, maxSize int64) (*cachingArtefactDownloader, func()) {
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+
+	cache, err := artefactcache.New(dir)
+	assert.Ok(t, err)
+
+	return &cachingArtefactDownloader{"https://example.com/dl/", inner, cache, maxSize}, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCachedEntryLargerThanMaxSize(t *testing.T) {
+	inner := &fakeArtefactDownloader{content: map[string]string{
+		"big.zip":   "larger than max size",
+		"other.zip": "also larger than max size",
+	}}
+
+	cached, cleanup := newTestCachingDownloader(t, inner, 5)
+	defer cleanup()
+
+	download := func(filename string) string {
+		content, err := cached.DownloadArtefact(context.Background(), filename)
+		assert.Ok(t, err)
+		defer content.Close()
+
+		contentBytes, err := ioutil.ReadAll(content)
+		assert.Ok(t, err)
+		return string(contentBytes)
+	}
+
+	cachedKeys := func() []string {
+		entries, err := cached.cache.List()
+		assert.Ok(t, err)
+
+		keys := []string{}
+		for _, entry := range entries {
+			keys = append(keys, entry.Origin.Filename)
+		}
+		return keys
+	}
+
+	// entry being returned is not evicted, even if it alone is over max size
+	assert.EqualString(t, download("big.zip"), "larger than max size")
+	assert.EqualString(t, strings.Join(cachedKeys(), ","), "big.zip")
+
+	// but it's evicted by the next download
+	assert.EqualString(t, download("other.zip"), "also larger than max size")
+	assert.EqualString(t, strings.Join(cachedKeys(), ","), "other.zip")
+}
+
+func TestChecksumMismatchEvictsCachedArtefact(t *testing.T) {
+	inner := &fakeArtefactDownloader{content: map[string]string{"app.zip": "tampered"}}
+
+	cached, cleanup := newTestCachingDownloader(t, inner, 1024)
+	defer cleanup()
+
+	downloadVerified := func() error {
+		content, err := cached.DownloadArtefact(context.Background(), "app.zip")
+		assert.Ok(t, err)
+		defer content.Close()
+
+		verified, err := verifyChecksumIfKnown(content, "app.zip", map[string]ddomain.ArtefactChecksum{
+			"app.zip": {Sha256: "0000", Size: 8},
+		})
+		assert.Ok(t, err)
+
+		_, err = io.Copy(ioutil.Discard, verified)
+		return evictIfChecksumMismatch(cached, "app.zip", err)
+	}
+
+	err := downloadVerified()
+	assert.Assert(t, err != nil)
+	assert.Assert(t, strings.HasPrefix(err.Error(), "checksum mismatch for app.zip"))
+
+	entries, err := cached.cache.List()
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 0)
+
+	// not served from cache, but downloaded again
+	assert.Assert(t, downloadVerified() != nil)
+	assert.Assert(t, inner.downloads == 2)
+}
@@ -2,15 +2,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/function61/deployer/pkg/credentials"
 	"github.com/function61/deployer/pkg/githubminiclient"
+	"github.com/function61/deployer/pkg/releasehost"
+	"github.com/function61/gokit/backoff"
 	"github.com/function61/gokit/ezhttp"
 )
 
@@ -18,13 +27,15 @@ type artefactDownloader interface {
 	DownloadArtefact(ctx context.Context, filename string) (io.ReadCloser, error)
 }
 
+// optionally implemented by artefactDownloader if it can download more robustly into a
+// file than by streaming (like resuming after a dropped connection). destination appears
+// only when the download is complete. progress (optional) receives the downloaded bytes.
+type artefactFileDownloader interface {
+	DownloadArtefactToFile(ctx context.Context, filename string, destination string, progress io.Writer) error
+}
+
 type githubReleasesArtefactDownloader struct {
-	owner        string
-	repo         string
-	releaseId    int64
-	gmc          *githubminiclient.Client
-	mu           sync.Mutex
-	cachedAssets []githubminiclient.Asset
+	*releaseHostArtefactDownloader
 }
 
 func newGithubReleasesArtefactDownloader(uri string, gmc *githubminiclient.Client) (*githubReleasesArtefactDownloader, error) {
@@ -39,52 +50,70 @@ func newGithubReleasesArtefactDownloader(uri string, gmc *githubminiclient.Clien
 		return nil, fmt.Errorf("expecting githubrelease; got '%s'", components[0])
 	}
 
-	releaseId, err := strconv.Atoi(components[3])
-	if err != nil {
+	// GitHub release IDs are numeric
+	if _, err := strconv.Atoi(components[3]); err != nil {
 		return nil, err
 	}
 
-	return &githubReleasesArtefactDownloader{
-		owner:     components[1],
-		repo:      components[2],
-		releaseId: int64(releaseId),
-		gmc:       gmc,
+	return &githubReleasesArtefactDownloader{newReleaseHostArtefactDownloader(
+		releasehost.GitHub(gmc),
+		releasehost.NewRepoRef(components[1], components[2]),
+		components[3]),
 	}, nil
 }
 
-func (g *githubReleasesArtefactDownloader) DownloadArtefact(
+// downloads artefacts from assets of a release in a release host (GitHub, Gitea, GitLab)
+type releaseHostArtefactDownloader struct {
+	host         releasehost.Host
+	repo         releasehost.RepoRef
+	releaseId    string
+	mu           sync.Mutex
+	cachedAssets []releasehost.Asset
+}
+
+func newReleaseHostArtefactDownloader(
+	host releasehost.Host,
+	repo releasehost.RepoRef,
+	releaseId string,
+) *releaseHostArtefactDownloader {
+	return &releaseHostArtefactDownloader{
+		host:      host,
+		repo:      repo,
+		releaseId: releaseId,
+	}
+}
+
+func (r *releaseHostArtefactDownloader) DownloadArtefact(
 	ctx context.Context,
 	filename string,
 ) (io.ReadCloser, error) {
-	if err := g.downloadAndCacheAssetMetadata(ctx); err != nil {
+	if err := r.downloadAndCacheAssetMetadata(ctx); err != nil {
 		return nil, err
 	}
 
-	for _, asset := range g.cachedAssets {
+	for _, asset := range r.cachedAssets {
 		if asset.Name == filename {
-			return g.gmc.DownloadAsset(ctx, asset)
+			return r.host.DownloadAsset(ctx, asset)
 		}
 	}
 
 	return nil, fmt.Errorf("asset to download not found: %s", filename)
 }
 
-func (g *githubReleasesArtefactDownloader) downloadAndCacheAssetMetadata(ctx context.Context) error {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+func (r *releaseHostArtefactDownloader) downloadAndCacheAssetMetadata(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	if g.cachedAssets != nil {
+	if r.cachedAssets != nil {
 		return nil
 	}
 
-	repo := githubminiclient.NewRepoRef(g.owner, g.repo)
-
-	assets, err := g.gmc.ListAssetsForRelease(ctx, repo, strconv.Itoa(int(g.releaseId)))
+	assets, err := r.host.ListAssets(ctx, r.repo, r.releaseId)
 	if err != nil {
 		return err
 	}
 
-	g.cachedAssets = assets
+	r.cachedAssets = assets
 
 	return nil
 }
@@ -109,6 +138,172 @@ func (h *httpArtefactDownloader) DownloadArtefact(
 	return res.Body, nil
 }
 
+// retries with exponential backoff, resuming via HTTP Range requests into a partial
+// file (which also survives across our invocations)
+func (h *httpArtefactDownloader) DownloadArtefactToFile(
+	ctx context.Context,
+	filename string,
+	destination string,
+	progress io.Writer,
+) error {
+	partialFilename := destination + ".partial"
+
+	if progress == nil {
+		progress = ioutil.Discard
+	}
+
+	const httpDownloadMaxAttempts = 8
+
+	nextBackoff := backoff.ExponentialWithCappedMax(1*time.Second, 30*time.Second)
+
+	for attempt := 1; ; attempt++ {
+		err := downloadOrResume(ctx, h.baseUrl+filename, partialFilename, progress)
+		if err == nil {
+			if err := os.Remove(partialValidatorFilename(partialFilename)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			return os.Rename(partialFilename, destination)
+		}
+
+		if attempt >= httpDownloadMaxAttempts || isPermanentHttpError(err) {
+			return err
+		}
+
+		log.Printf("download %s try %d failed (will resume): %v", filename, attempt, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(nextBackoff()):
+		}
+	}
+}
+
+// ETag or Last-Modified of the response the partial file started from. resuming is only
+// safe if the remote file is still the same (If-Range), otherwise we'd stitch together
+// pieces of two different files.
+func partialValidatorFilename(partialFilename string) string {
+	return partialFilename + ".validator"
+}
+
+func downloadOrResume(ctx context.Context, url string, partialFilename string, progress io.Writer) error {
+	partial, err := os.OpenFile(partialFilename, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer partial.Close() // also releases the lock
+
+	// two writers would garble the content. not waiting for the lock, because when the
+	// other writer finishes, it renames the file away from under us.
+	if err := syscall.Flock(int(partial.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return fmt.Errorf("%s is being written by another download", partialFilename)
+		}
+
+		return err
+	}
+
+	offset, err := partial.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	validator := ""
+	if offset > 0 {
+		validatorBytes, err := ioutil.ReadFile(partialValidatorFilename(partialFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		validator = string(validatorBytes)
+	}
+
+	headers := []ezhttp.ConfigPiece{}
+	if validator != "" {
+		headers = append(
+			headers,
+			ezhttp.Header("Range", fmt.Sprintf("bytes=%d-", offset)),
+			ezhttp.Header("If-Range", validator))
+	}
+
+	res, err := ezhttp.Get(ctx, url, headers...)
+	if err != nil {
+		statusErr := &ezhttp.ResponseStatusError{}
+		if errors.As(err, &statusErr) && statusErr.StatusCode() == http.StatusRequestedRangeNotSatisfiable {
+			// "bytes */<size>" => we already have everything, if size matches
+			if res.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+				return nil
+			}
+
+			// partial file is somehow bigger than the remote file. start over.
+			if err := partial.Truncate(0); err != nil {
+				return err
+			}
+
+			// %v so this doesn't look like a permanent error
+			return fmt.Errorf("partial file invalid (%v), starting over", err)
+		}
+
+		return err
+	}
+	defer res.Body.Close()
+
+	if validator != "" && res.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return fmt.Errorf("unexpected Content-Range: %s", res.Header.Get("Content-Range"))
+		}
+	} else {
+		// fresh start (remote file changed, server doesn't support ranges or we didn't ask for one)
+		if err := partial.Truncate(0); err != nil {
+			return err
+		}
+
+		if _, err := partial.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(partialValidatorFilename(partialFilename), []byte(rangeValidator(res)), 0644); err != nil {
+			return err
+		}
+	}
+
+	written, err := io.Copy(io.MultiWriter(partial, progress), res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.ContentLength != -1 && written != res.ContentLength {
+		return fmt.Errorf("short read: expected %d bytes, got %d", res.ContentLength, written)
+	}
+
+	return partial.Close()
+}
+
+// "" if response can't be resumed safely. If-Range doesn't accept weak ETags.
+func rangeValidator(res *http.Response) string {
+	if etag := res.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return res.Header.Get("Last-Modified")
+}
+
+// client errors (except timeouts and throttling) won't get better by retrying
+func isPermanentHttpError(err error) bool {
+	statusErr := &ezhttp.ResponseStatusError{}
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+
+	switch code := statusErr.StatusCode(); {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests:
+		return false
+	default:
+		return code >= 400 && code < 500
+	}
+}
+
 // in practice makes a copy of a local file
 type localFileDownloader struct {
 	path string
@@ -127,14 +322,30 @@ func (f *localFileDownloader) DownloadArtefact(ctx context.Context, filename str
 	return file, nil
 }
 
-func makeArtefactDownloader(ctx context.Context, uri string, gmc *githubminiclient.Client) (artefactDownloader, error) {
+// credentials are looked up lazily only for schemes that need them. nil creds = no credentials.
+func makeArtefactDownloader(ctx context.Context, uri string, creds credentials.Provider) (artefactDownloader, error) {
+	if creds == nil {
+		creds = credentials.Chain()
+	}
+
 	switch {
 	case strings.HasPrefix(uri, "file:"):
 		return newLocalFileDownloader(uri[len("file:"):]), nil
 	case strings.HasPrefix(uri, "http:"), strings.HasPrefix(uri, "https:"):
 		return newhttpArtefactDownloader(uri), nil
 	case strings.HasPrefix(uri, "githubrelease:"):
+		gmc, err := githubminiclient.NewWithEndpoint(githubApiEndpoint(), func() (string, error) {
+			return credentials.Required(creds, githubTokenName)
+		})
+		if err != nil {
+			return nil, err
+		}
+
 		return newGithubReleasesArtefactDownloader(uri, gmc)
+	case strings.HasPrefix(uri, giteaReleaseScheme), strings.HasPrefix(uri, gitlabReleaseScheme):
+		return newSelfHostedReleaseArtefactDownloader(uri, creds)
+	case strings.HasPrefix(uri, s3Scheme):
+		return newS3ArtefactDownloader(uri)
 	case strings.HasPrefix(uri, "docker://"):
 		return newOCIArtefactDownloader(ctx, uri[len("docker://"):])
 	default:
@@ -2,8 +2,17 @@ package main
 
 import (
 	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"testing"
 
+	"github.com/function61/deployer/pkg/credentials"
 	"github.com/function61/gokit/assert"
 )
 
@@ -14,9 +23,17 @@ func TestGithubReleases(t *testing.T) {
 
 	ghr := downloader.(*githubReleasesArtefactDownloader)
 
-	assert.EqualString(t, ghr.owner, "function61")
-	assert.EqualString(t, ghr.repo, "coolproduct")
-	assert.Assert(t, ghr.releaseId == 12345)
+	assert.EqualString(t, ghr.repo.Owner, "function61")
+	assert.EqualString(t, ghr.repo.Name, "coolproduct")
+	assert.EqualString(t, ghr.releaseId, "12345")
+}
+
+func TestGithubReleasesTokenIsLookedUpLazily(t *testing.T) {
+	downloader, err := makeArtefactDownloader(context.TODO(), "githubrelease:function61:coolproduct:12345", credentials.Chain())
+	assert.Ok(t, err)
+
+	_, err = downloader.DownloadArtefact(context.TODO(), "deployerspec.zip")
+	assert.EqualString(t, err.Error(), "credential GITHUB_TOKEN not found (looked from: )")
 }
 
 func TestHttp(t *testing.T) {
@@ -39,8 +56,161 @@ func TestHttps(t *testing.T) {
 	assert.EqualString(t, had.baseUrl, "https://downloads.example.com/")
 }
 
+func TestGiteaRelease(t *testing.T) {
+	downloader, err := makeArtefactDownloader(context.TODO(), "gitearelease:git.example.com/function61/coolproduct/12", nil)
+	assert.Ok(t, err)
+
+	rhad := downloader.(*releaseHostArtefactDownloader)
+
+	assert.EqualString(t, rhad.repo.Owner, "function61")
+	assert.EqualString(t, rhad.repo.Name, "coolproduct")
+	assert.EqualString(t, rhad.releaseId, "12")
+}
+
+func TestSelfHostedReleaseLocation(t *testing.T) {
+	location, err := parseSelfHostedReleaseLocation("gitlabrelease:gitlab.com/group/subgroup/coolproduct/v1.2.3")
+	assert.Ok(t, err)
+
+	assert.EqualString(t, location.baseUrl, "https://gitlab.com")
+	assert.EqualString(t, location.repo.Owner, "group/subgroup")
+	assert.EqualString(t, location.repo.Name, "coolproduct")
+	assert.EqualString(t, location.releaseId, "v1.2.3")
+	assert.EqualString(t, location.String(), "gitlabrelease:gitlab.com/group/subgroup/coolproduct/v1.2.3")
+
+	location, err = parseSelfHostedReleaseLocation("gitearelease:http://localhost:3000/function61/coolproduct/1")
+	assert.Ok(t, err)
+
+	assert.EqualString(t, location.baseUrl, "http://localhost:3000")
+	assert.EqualString(t, location.String(), "gitearelease:http://localhost:3000/function61/coolproduct/1")
+
+	_, err = parseSelfHostedReleaseLocation("gitearelease:git.example.com/coolproduct/1")
+	assert.EqualString(t, err.Error(), "invalid syntax for gitearelease:; expecting host/owner/repo/releaseId, got git.example.com/coolproduct/1")
+}
+
 func TestUnsupportedUri(t *testing.T) {
 	_, err := makeArtefactDownloader(context.TODO(), "ftp://stuff", nil)
 
 	assert.EqualString(t, err.Error(), "unsupported URI: ftp://stuff")
 }
+
+func TestHttpDownloadResumesAfterDroppedConnection(t *testing.T) {
+	content := strings.Repeat("0123456789", 1000)
+
+	downloaded, requests := downloadFromFlakyServer(t, func(attempt int) (string, string) {
+		return `"v1"`, content
+	})
+
+	assert.Assert(t, downloaded == content)
+	assert.EqualString(t, strings.Join(requests, ","), `,bytes=5000- "v1"`)
+}
+
+func TestHttpDownloadStartsOverIfFileChanged(t *testing.T) {
+	contentV1 := strings.Repeat("0123456789", 1000)
+	contentV2 := strings.Repeat("abcdefghij", 1000)
+
+	downloaded, requests := downloadFromFlakyServer(t, func(attempt int) (string, string) {
+		if attempt == 1 {
+			return `"v1"`, contentV1
+		}
+
+		return `"v2"`, contentV2
+	})
+
+	assert.Assert(t, downloaded == contentV2)
+	assert.EqualString(t, strings.Join(requests, ","), `,bytes=5000- "v1"`)
+}
+
+// first attempt promises everything, delivers half and drops the connection. honors
+// If-Range, so if content changed between attempts, whole new content is sent.
+func downloadFromFlakyServer(t *testing.T, contentForAttempt func(attempt int) (string, string)) (string, []string) {
+	t.Helper()
+
+	requests := []string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, strings.TrimSpace(r.Header.Get("Range")+" "+r.Header.Get("If-Range")))
+
+		etag, content := contentForAttempt(len(requests))
+		w.Header().Set("ETag", etag)
+
+		rangeHeader := r.Header.Get("Range")
+		if rangeHeader == "" || r.Header.Get("If-Range") != etag {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+
+			if len(requests) > 1 {
+				_, _ = w.Write([]byte(content))
+				return
+			}
+
+			_, _ = w.Write([]byte(content[:len(content)/2]))
+
+			conn, _, err := w.(http.Hijacker).Hijack()
+			assert.Ok(t, err)
+			conn.Close()
+			return
+		}
+
+		offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rangeHeader, "bytes="), "-"))
+
+		w.Header().Set("Content-Range", "bytes "+strconv.Itoa(offset)+"-"+strconv.Itoa(len(content)-1)+"/"+strconv.Itoa(len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte(content[offset:]))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	destination := filepath.Join(dir, "app.tar.gz")
+
+	downloader := &httpArtefactDownloader{server.URL + "/"}
+	assert.Ok(t, downloader.DownloadArtefactToFile(context.TODO(), "app.tar.gz", destination, nil))
+
+	downloaded, err := ioutil.ReadFile(destination)
+	assert.Ok(t, err)
+
+	// partial file's bookkeeping was cleaned up
+	leftovers, err := ioutil.ReadDir(dir)
+	assert.Ok(t, err)
+	assert.Assert(t, len(leftovers) == 1)
+
+	return string(downloaded), requests
+}
+
+func TestHttpDownloadDoesNotRetryNotFound(t *testing.T) {
+	requests := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	downloader := &httpArtefactDownloader{server.URL + "/"}
+	err = downloader.DownloadArtefactToFile(context.TODO(), "app.tar.gz", filepath.Join(dir, "app.tar.gz"), nil)
+
+	assert.Assert(t, err != nil)
+	assert.Assert(t, requests == 1)
+}
+
+func TestHttpDownloadRefusesPartialFileInUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	partialFilename := filepath.Join(dir, "app.tar.gz.partial")
+
+	other, err := os.Create(partialFilename)
+	assert.Ok(t, err)
+	defer other.Close()
+	assert.Ok(t, syscall.Flock(int(other.Fd()), syscall.LOCK_EX))
+
+	err = downloadOrResume(context.TODO(), "http://127.0.0.1:1/app.tar.gz", partialFilename, ioutil.Discard)
+	assert.EqualString(t, err.Error(), partialFilename+" is being written by another download")
+}
@@ -32,12 +32,13 @@ func deploymentCreateConfig(ctx context.Context, serviceId string, releaseId str
 
 	// .. but the deployment for this service must not exist
 
-	app, err := mkApp(ctx)
+	app, err := mkAppIfNeeded(ctx, releaseId)
 	if err != nil {
 		return err
 	}
 
-	if err := downloadRelease(ctx, serviceId, releaseId, app); err != nil {
+	// no trusted keys yet, since the config is what we're about to create
+	if err := downloadRelease(ctx, serviceId, releaseId, "", app, nil); err != nil {
 		return fmt.Errorf("downloadRelease: %w", err)
 	}
 
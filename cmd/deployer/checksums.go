@@ -0,0 +1,178 @@
+package main
+
+// Records and verifies SHA-256 checksums of artefacts
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/gokit/atomicfilewrite"
+)
+
+func checksumOfFile(path string) (*ddomain.ArtefactChecksum, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return checksumOfReader(file)
+}
+
+func checksumOfReader(content io.Reader) (*ddomain.ArtefactChecksum, error) {
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ddomain.ArtefactChecksum{
+		Sha256: fmt.Sprintf("%x", hash.Sum(nil)),
+		Size:   size,
+	}, nil
+}
+
+// checksums of all files in dir (non-recursive), keyed by filename
+func checksumsOfDir(dir string) (map[string]ddomain.ArtefactChecksum, error) {
+	filePaths, err := filesInDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	checksums := map[string]ddomain.ArtefactChecksum{}
+
+	for _, filePath := range filePaths {
+		checksum, err := checksumOfFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		checksums[filepath.Base(filePath)] = *checksum
+	}
+
+	return checksums, nil
+}
+
+// paths of files in dir (non-recursive)
+func filesInDir(dir string) ([]string, error) {
+	dentries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	filePaths := []string{}
+
+	for _, dentry := range dentries {
+		if dentry.IsDir() {
+			continue
+		}
+
+		filePaths = append(filePaths, filepath.Join(dir, dentry.Name()))
+	}
+
+	return filePaths, nil
+}
+
+// returns error at EOF if content didn't match the expected checksum
+func newChecksumVerifyingReader(
+	content io.Reader,
+	filename string,
+	expected ddomain.ArtefactChecksum,
+) io.Reader {
+	return &checksumVerifyingReader{
+		content:  content,
+		filename: filename,
+		expected: expected,
+		hash:     sha256.New(),
+	}
+}
+
+type checksumVerifyingReader struct {
+	content  io.Reader
+	filename string
+	expected ddomain.ArtefactChecksum
+	hash     hash.Hash
+	size     int64
+}
+
+func (c *checksumVerifyingReader) Read(p []byte) (int, error) {
+	n, err := c.content.Read(p)
+	c.size += int64(n)
+	_, _ = c.hash.Write(p[:n]) // never returns an error
+
+	if err == io.EOF {
+		actual := ddomain.ArtefactChecksum{
+			Sha256: fmt.Sprintf("%x", c.hash.Sum(nil)),
+			Size:   c.size,
+		}
+
+		if actual != c.expected {
+			return n, &checksumMismatchError{c.filename, c.expected, actual}
+		}
+	}
+
+	return n, err
+}
+
+type checksumMismatchError struct {
+	filename string
+	expected ddomain.ArtefactChecksum
+	actual   ddomain.ArtefactChecksum
+}
+
+func (c *checksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"checksum mismatch for %s: expected sha256 %s (%d bytes), got %s (%d bytes)",
+		c.filename,
+		c.expected.Sha256,
+		c.expected.Size,
+		c.actual.Sha256,
+		c.actual.Size)
+}
+
+// if we know any checksums, every file must be covered by them
+func verifyChecksumIfKnown(
+	content io.Reader,
+	filename string,
+	checksums map[string]ddomain.ArtefactChecksum,
+) (io.Reader, error) {
+	if len(checksums) == 0 {
+		return content, nil
+	}
+
+	expected, found := checksums[filename]
+	if !found {
+		return nil, fmt.Errorf("no checksum recorded for %s", filename)
+	}
+
+	return newChecksumVerifyingReader(content, filename, expected), nil
+}
+
+// in the format of "$ sha256sum", so artefacts can be verified without us
+const sha256SumsFilename = "SHA256SUMS"
+
+func writeSha256Sums(path string, checksums map[string]ddomain.ArtefactChecksum) error {
+	filenames := []string{}
+	for filename := range checksums {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	return atomicfilewrite.Write(path, func(sink io.Writer) error {
+		for _, filename := range filenames {
+			if _, err := fmt.Fprintf(sink, "%s  %s\n", checksums[filename].Sha256, filename); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/gokit/assert"
+)
+
+func TestChecksumVerifyingReader(t *testing.T) {
+	expected := ddomain.ArtefactChecksum{
+		Sha256: "d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592",
+		Size:   43,
+	}
+
+	_, err := ioutil.ReadAll(newChecksumVerifyingReader(
+		strings.NewReader("The quick brown fox jumps over the lazy dog"),
+		"fox.txt",
+		expected))
+	assert.Ok(t, err)
+
+	_, err = ioutil.ReadAll(newChecksumVerifyingReader(
+		strings.NewReader("The quick brown fox jumps over the lazy cat"),
+		"fox.txt",
+		expected))
+	assert.EqualString(t, err.Error(), "checksum mismatch for fox.txt: expected sha256 d7a8fbb307d7809469ca9abcb0082e4f8d5651e46d3cdb762d02d0bf37c9e592 (43 bytes), got 84afe243716c389f4ec2e8aa435b616960ad70886079bd62de6e2e8300e2a8f3 (43 bytes)")
+}
+
+func TestWriteSha256Sums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	assert.Ok(t, writeSha256Sums(filepath.Join(dir, sha256SumsFilename), map[string]ddomain.ArtefactChecksum{
+		"deployerspec.zip": {Sha256: "84afe243716c389f4ec2e8aa435b616960ad70886079bd62de6e2e8300e2a8f3", Size: 5},
+		"app.tar.gz":       {Sha256: "8a39d2abd3999ab73c34db2476849cddf303ce389b35826850f9a700589b4a90", Size: 3},
+	}))
+
+	sums, err := ioutil.ReadFile(filepath.Join(dir, sha256SumsFilename))
+	assert.Ok(t, err)
+
+	assert.EqualString(t, string(sums), `8a39d2abd3999ab73c34db2476849cddf303ce389b35826850f9a700589b4a90  app.tar.gz
+84afe243716c389f4ec2e8aa435b616960ad70886079bd62de6e2e8300e2a8f3  deployerspec.zip
+`)
+}
@@ -0,0 +1,230 @@
+package main
+
+// Runs commands in the deployer image. Docker by default, but some hosts only have
+// (rootless) Podman or nerdctl, and CI jobs might already run inside the deployer image,
+// in which case the command is run directly on the host ("local").
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	containerRunnersSupported = "docker, podman, nerdctl, local"
+	runnerFlagUsage           = "What runs the deployer image: " + containerRunnersSupported + " (default: runner from deployment config, or docker)"
+)
+
+type containerRunner interface {
+	// returns command that runs commandToRun in deployer image of the deployment.
+	// attachStdin is implied by deployment.Tty.
+	Command(deployment Deployment, commandToRun []string, attachStdin bool) (*exec.Cmd, error)
+	// asks started command to stop, killing it if it doesn't stop within containerStopGracePeriod.
+	// fails if the container was not yet started, so call again later.
+	Stop(deployment Deployment, cmd *exec.Cmd) error
+}
+
+// "" means default (Docker)
+func containerRunnerByName(name string) (containerRunner, error) {
+	switch name {
+	case "", "docker":
+		return &cliContainerRunner{"docker"}, nil
+	case "podman", "nerdctl": // CLIs are compatible with Docker's for our needs
+		return &cliContainerRunner{name}, nil
+	case "local":
+		return &localRunner{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported runner: %s (supported: %s)", name, containerRunnersSupported)
+	}
+}
+
+// runs the container with Docker-compatible CLI
+type cliContainerRunner struct {
+	binary string
+}
+
+func (c *cliContainerRunner) Command(
+	deployment Deployment,
+	commandToRun []string,
+	attachStdin bool,
+) (*exec.Cmd, error) {
+	envsAsDocker := []string{}
+	for _, env := range deploymentEnvs(deployment, "/work", "/state") {
+		envsAsDocker = append(envsAsDocker, "-e", env)
+	}
+
+	// needed if tools inside container make excessive use of symlinks, like Terraform:
+	// https://twitter.com/joonas_fi/status/1129316321743855616
+	useShim := true // TODO: make this opt-in?
+
+	workDirMount := "/work"
+	if useShim {
+		workDirMount = shimDirectory
+	}
+
+	dockerArgs := append([]string{
+		c.binary,
+		"run",
+		"--rm",
+		"--name", containerName(deployment.UserConfig.ServiceID),
+		"--init", // so SIGTERM from "$ docker stop" reaches the command even if it doesn't handle signals as PID 1
+		"-v", workDir(deployment.UserConfig.ServiceID) + ":" + workDirMount,
+		"-v", stateDir(deployment.UserConfig.ServiceID) + ":/state",
+		"--entrypoint", "", // if image specifies entrypoint, our explicit command would get confused
+		"--workdir", "/work",
+	}, envsAsDocker...)
+
+	pushDockerArg := func(args ...string) { dockerArgs = append(dockerArgs, args...) }
+
+	switch {
+	case deployment.Tty:
+		pushDockerArg("-it")
+	case attachStdin:
+		pushDockerArg("-i")
+	}
+
+	if useShim {
+		// bind mount us (the process that is currently running) at /shim, so we can launch
+		// ourselves inside the container for doing the shim dance (copy the work dir) inside container
+		ourExecutable, err := os.Executable()
+		if err != nil {
+			return nil, err
+		}
+
+		pushDockerArg("-v", ourExecutable+":"+shimBinaryMountPoint)
+	}
+
+	pushDockerArg(deployment.Vam.Manifest.DeployerImage)
+
+	if useShim {
+		// NOTE: -- to target argv from being parsed for context of the shim
+		pushDockerArg(shimBinaryMountPoint, "launch-via-shim", "--")
+	}
+
+	dockerArgs = append(dockerArgs, commandViaShellIfNeeded(commandToRun, "")...)
+
+	//nolint:gosec // ok
+	return exec.Command(dockerArgs[0], dockerArgs[1:]...), nil
+}
+
+// stopping the CLI process would leave the container running, so stop the container by name
+func (c *cliContainerRunner) Stop(deployment Deployment, _ *exec.Cmd) error {
+	//nolint:gosec // ok
+	return exec.Command(
+		c.binary,
+		"stop",
+		"--time="+strconv.Itoa(int(containerStopGracePeriod.Seconds())),
+		containerName(deployment.UserConfig.ServiceID),
+	).Run()
+}
+
+// runs the command directly on the host, with the host's tools (deployer image is not used).
+// there are no mounts, so the command runs in the work dir and dirs that are at /work and
+// /state in the container are given as $DEPLOYER_WORK_DIR and $DEPLOYER_STATE_DIR.
+// commands that reference /work or /state are refused, as they'd point to the host's root.
+type localRunner struct{}
+
+// "/state", "/state/foo" or "cat /state/foo" but not "/statefile" or "./state"
+var containerOnlyPath = regexp.MustCompile(`(^|[^a-zA-Z0-9_./-])/(work|state)(/|$|[^a-zA-Z0-9_.-])`)
+
+func (l *localRunner) Command(
+	deployment Deployment,
+	commandToRun []string,
+	_ bool, // stdin is attached by runWithOutput() etc.
+) (*exec.Cmd, error) {
+	serviceId := deployment.UserConfig.ServiceID
+
+	// container has state dir as mount, so it exists even if nothing has been stored yet
+	if err := os.MkdirAll(stateDir(serviceId), 0700); err != nil {
+		return nil, err
+	}
+
+	if len(commandToRun) == 0 {
+		return nil, errors.New("no command to run")
+	}
+
+	if err := refuseContainerOnlyPaths(deployment, commandToRun); err != nil {
+		return nil, err
+	}
+
+	// exec so SIGTERM from Stop() reaches the command instead of the shell
+	argv := commandViaShellIfNeeded(commandToRun, "exec ")
+
+	//nolint:gosec // ok
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Dir = workDir(serviceId)
+	cmd.Env = append(os.Environ(), deploymentEnvs(deployment, workDir(serviceId), stateDir(serviceId))...)
+
+	return cmd, nil
+}
+
+func (l *localRunner) Stop(_ Deployment, cmd *exec.Cmd) error {
+	if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		return err
+	}
+
+	// no-op if the process has exited by then
+	time.AfterFunc(containerStopGracePeriod, func() {
+		_ = cmd.Process.Kill()
+	})
+
+	return nil
+}
+
+// scripts inside the release can't be checked, but command and envs can
+func refuseContainerOnlyPaths(deployment Deployment, commandToRun []string) error {
+	for _, arg := range commandToRun {
+		if containerOnlyPath.MatchString(arg) {
+			return fmt.Errorf(
+				"local runner: command refers to /work or /state, which only exist in container (use $DEPLOYER_WORK_DIR or $DEPLOYER_STATE_DIR): %s",
+				arg)
+		}
+	}
+
+	for key, value := range deployment.UserConfig.Envs {
+		if containerOnlyPath.MatchString(value) {
+			return fmt.Errorf(
+				"local runner: env %s refers to /work or /state, which only exist in container: %s",
+				key,
+				value)
+		}
+	}
+
+	return nil
+}
+
+// "KEY=value" pairs given to the command, regardless of runner. workDirPath and
+// stateDirPath are as seen by the command.
+func deploymentEnvs(deployment Deployment, workDirPath string, stateDirPath string) []string {
+	userEnvs := []string{}
+	for key, value := range deployment.UserConfig.Envs {
+		userEnvs = append(userEnvs, key+"="+value)
+	}
+	sort.Strings(userEnvs)
+
+	return append([]string{
+		"FRIENDLY_REV_ID=" + deployment.Vam.Version.FriendlyVersion,
+		"DEPLOYER_WORK_DIR=" + workDirPath,
+		"DEPLOYER_STATE_DIR=" + stateDirPath,
+	}, userEnvs...)
+}
+
+// commands that aren't absolute paths are run via shell, so they can be relative to work
+// dir or looked up from $PATH. shellPrefix is prepended to the shell's command line.
+func commandViaShellIfNeeded(commandToRun []string, shellPrefix string) []string {
+	// len check so [0] access doesn't fail, though that shouldn't happen
+	useShell := len(commandToRun) > 0 && !strings.HasPrefix(commandToRun[0], "/")
+
+	if useShell {
+		return []string{"/bin/sh", "-c", shellPrefix + strings.Join(shellEscape(commandToRun), " ")}
+	}
+
+	return commandToRun
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestContainerRunnerByName(t *testing.T) {
+	runnerBinary := func(name string) string {
+		runner, err := containerRunnerByName(name)
+		if err != nil {
+			return err.Error()
+		}
+
+		switch runner := runner.(type) {
+		case *cliContainerRunner:
+			return runner.binary
+		case *localRunner:
+			return "(local)"
+		default:
+			return "(unknown)"
+		}
+	}
+
+	assert.EqualString(t, runnerBinary(""), "docker")
+	assert.EqualString(t, runnerBinary("docker"), "docker")
+	assert.EqualString(t, runnerBinary("podman"), "podman")
+	assert.EqualString(t, runnerBinary("nerdctl"), "nerdctl")
+	assert.EqualString(t, runnerBinary("local"), "(local)")
+	assert.EqualString(t, runnerBinary("lxc"), "unsupported runner: lxc (supported: docker, podman, nerdctl, local)")
+}
+
+func TestCliContainerRunnerCommand(t *testing.T) {
+	cmd, err := (&cliContainerRunner{"podman"}).Command(testRunnerDeployment(), []string{"deploy.sh", "--id", "my app"}, false)
+	assert.Ok(t, err)
+
+	args := strings.Join(cmd.Args, " ")
+
+	assert.Assert(t, strings.HasPrefix(args, "podman run --rm --name deployer-hq --init -v "+workDir("hq")+":/shim-work-copy -v "+stateDir("hq")+":/state "))
+	assert.Assert(t, strings.Contains(args, " -e FRIENDLY_REV_ID=v314 -e DEPLOYER_WORK_DIR=/work -e DEPLOYER_STATE_DIR=/state -e appId=myTestApp "))
+	assert.Assert(t, strings.HasSuffix(args, " fn61/infrastructureascode:20200101 /shim launch-via-shim -- /bin/sh -c deploy.sh --id 'my app'"))
+}
+
+func TestLocalRunner(t *testing.T) {
+	_, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	assert.Ok(t, os.MkdirAll(workDir("hq"), 0755))
+
+	cmd, err := (&localRunner{}).Command(
+		testRunnerDeployment(),
+		[]string{"sh", "-c", `echo "$FRIENDLY_REV_ID $appId" > "$DEPLOYER_STATE_DIR/out.txt"; pwd`},
+		false)
+	assert.Ok(t, err)
+
+	pwd, err := cmd.Output()
+	assert.Ok(t, err)
+
+	// ran in work dir. pwd can resolve symlinks in temp dir path.
+	assert.EqualString(t, filepath.Base(strings.TrimSpace(string(pwd))), "work")
+
+	out, err := ioutil.ReadFile(filepath.Join(stateDir("hq"), "out.txt"))
+	assert.Ok(t, err)
+	assert.EqualString(t, string(out), "v314 myTestApp\n")
+}
+
+func TestLocalRunnerRefusesContainerOnlyPaths(t *testing.T) {
+	refused := func(command ...string) string {
+		if err := refuseContainerOnlyPaths(testRunnerDeployment(), command); err != nil {
+			return err.Error()
+		}
+
+		return "ok"
+	}
+
+	assert.EqualString(t, refused("./deploy.sh", "--state-file", "state.json"), "ok")
+	assert.EqualString(t, refused("cat", "/statefile", "./state/foo", "/srv/work/x"), "ok")
+	assert.EqualString(t, refused("cat", "/state/foo"), "local runner: command refers to /work or /state, which only exist in container (use $DEPLOYER_WORK_DIR or $DEPLOYER_STATE_DIR): /state/foo")
+	assert.EqualString(t, refused("/work/deploy.sh"), "local runner: command refers to /work or /state, which only exist in container (use $DEPLOYER_WORK_DIR or $DEPLOYER_STATE_DIR): /work/deploy.sh")
+	assert.EqualString(t, refused("sh", "-c", "terraform apply -state=/state"), "local runner: command refers to /work or /state, which only exist in container (use $DEPLOYER_WORK_DIR or $DEPLOYER_STATE_DIR): terraform apply -state=/state")
+
+	deployment := testRunnerDeployment()
+	deployment.UserConfig.Envs["TF_DATA_DIR"] = "/state/.terraform"
+	assert.EqualString(t, refuseContainerOnlyPaths(deployment, []string{"./deploy.sh"}).Error(), "local runner: env TF_DATA_DIR refers to /work or /state, which only exist in container: /state/.terraform")
+}
+
+func testRunnerDeployment() Deployment {
+	return Deployment{
+		Vam: VersionAndManifest{
+			Version: VersionFile{
+				FriendlyVersion: "v314",
+			},
+			Manifest: DeplSpecManifest{
+				DeployerImage: "fn61/infrastructureascode:20200101",
+			},
+		},
+		UserConfig: UserConfig{
+			ServiceID: "hq",
+			Envs: map[string]string{
+				"appId": "myTestApp",
+			},
+		},
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+// We don't use exec.CommandContext() for the deployer container, because it would SIGKILL
+// the docker CLI and leave the container running. Instead, on cancel (interrupt or timeout)
+// we ask the runner to stop the container, which sends SIGTERM and only kills after a grace
+// period, so f.ex. Terraform has a chance to release its state lock.
+
+import (
+	"context"
+	"log"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+const containerStopGracePeriod = 60 * time.Second
+
+var unsafeContainerNameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// named per service so it can be stopped by name (and so two concurrent deployments of
+// the same service would fail instead of stepping on each others' toes)
+func containerName(serviceId string) string {
+	return "deployer-" + unsafeContainerNameChars.ReplaceAllString(serviceId, "_")
+}
+
+// call after cmd is started. call the returned function when cmd has exited.
+func stopContainerOnCancel(
+	ctx context.Context,
+	runner containerRunner,
+	deployment Deployment,
+	cmd *exec.Cmd,
+) func() {
+	exited := make(chan interface{})
+
+	go func() {
+		select {
+		case <-exited:
+			return
+		case <-ctx.Done():
+		}
+
+		log.Printf(
+			"stopping container %s (%v); killed if not stopped within %s",
+			containerName(deployment.UserConfig.ServiceID),
+			ctx.Err(),
+			containerStopGracePeriod)
+
+		for {
+			// fails if container was not yet started, in which case we'll retry
+			if err := runner.Stop(deployment, cmd); err == nil {
+				return
+			}
+
+			select {
+			case <-exited:
+				return
+			case <-time.After(1 * time.Second):
+			}
+		}
+	}()
+
+	return func() {
+		close(exited)
+	}
+}
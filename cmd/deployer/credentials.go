@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/function61/deployer/pkg/credentials"
+)
+
+const githubTokenName = "GITHUB_TOKEN"
+
+// where credentials are looked from, in order:
+// - ENV vars
+// - credentials file ($DEPLOYER_CREDENTIALS_FILE or ~/.config/deployer/credentials.json)
+// - OS keyring (service "deployer")
+// - credential helper command ($DEPLOYER_CREDENTIAL_HELPER), if configured
+func credentialsProvider() credentials.Provider {
+	providers := []credentials.Provider{
+		credentials.Env(),
+	}
+
+	if path, ok := credentialsFilePath(); ok {
+		providers = append(providers, credentials.File(path))
+	}
+
+	providers = append(providers, credentials.Keyring("deployer"))
+
+	if helper := os.Getenv("DEPLOYER_CREDENTIAL_HELPER"); helper != "" {
+		providers = append(providers, credentials.Helper(helper))
+	}
+
+	return credentials.Chain(providers...)
+}
+
+// false if there's no config dir ($HOME not set). we don't fall back to a relative path,
+// because then credentials would be read from whichever dir we happen to be run in.
+func credentialsFilePath() (string, bool) {
+	if path := os.Getenv("DEPLOYER_CREDENTIALS_FILE"); path != "" {
+		return path, true
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+
+	return filepath.Join(configDir, "deployer", "credentials.json"), true
+}
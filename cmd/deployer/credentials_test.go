@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestCredentialsFilePath(t *testing.T) {
+	restoreEnv := setEnvs(map[string]string{
+		"DEPLOYER_CREDENTIALS_FILE": "",
+		"XDG_CONFIG_HOME":           "/tmp/config",
+	})
+	defer restoreEnv()
+
+	path, ok := credentialsFilePath()
+	assert.Assert(t, ok)
+	assert.EqualString(t, path, "/tmp/config/deployer/credentials.json")
+
+	restoreEnv2 := setEnvs(map[string]string{
+		"DEPLOYER_CREDENTIALS_FILE": "/etc/deployer/credentials.json",
+	})
+	defer restoreEnv2()
+
+	path, ok = credentialsFilePath()
+	assert.Assert(t, ok)
+	assert.EqualString(t, path, "/etc/deployer/credentials.json")
+}
+
+func TestCredentialsFilePathWithoutHome(t *testing.T) {
+	restoreEnv := setEnvs(map[string]string{
+		"DEPLOYER_CREDENTIALS_FILE": "",
+		"XDG_CONFIG_HOME":           "",
+		"HOME":                      "",
+	})
+	defer restoreEnv()
+
+	// not relative "credentials.json", which would depend on working dir
+	_, ok := credentialsFilePath()
+	assert.Assert(t, !ok)
+}
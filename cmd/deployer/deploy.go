@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/alessio/shellescape"
 	"github.com/function61/deployer/pkg/dstate"
@@ -19,7 +21,12 @@ func interactive(ctx context.Context, deployment Deployment) error {
 		interactiveCommand = []string{"/bin/bash"}
 	}
 
-	dockerRun, err := prepareDockerRun(ctx, deployment, interactiveCommand)
+	runner, err := containerRunnerByName(deployment.UserConfig.Runner)
+	if err != nil {
+		return err
+	}
+
+	run, err := runner.Command(deployment, interactiveCommand, true)
 	if err != nil {
 		return err
 	}
@@ -29,115 +36,107 @@ func interactive(ctx context.Context, deployment Deployment) error {
 		strings.Join(interactiveCommand, " "),
 		strings.Join(deployment.ExpandedDeployCommand, " "))
 
-	redirectStandardStreams(dockerRun)
+	redirectStandardStreams(run)
 
-	if err := dockerRun.Start(); err != nil {
+	if err := run.Start(); err != nil {
 		return err
 	}
 
-	return dockerRun.Wait()
+	defer stopContainerOnCancel(ctx, runner, deployment, run)()
+
+	return run.Wait()
 }
 
 func deploy(ctx context.Context, deployment Deployment) error {
-	dockerRun, err := prepareDockerRun(ctx, deployment, deployment.ExpandedDeployCommand)
-	if err != nil {
-		return err
-	}
+	return runWithDeploymentLog(deployment, func(logOutput io.Writer) error {
+		return runInDeployerImage(ctx, deployment, deployment.ExpandedDeployCommand, logOutput)
+	})
+}
 
-	redirectStandardStreams(dockerRun)
+// logOutput is optional
+func runInDeployerImage(ctx context.Context, deployment Deployment, command []string, logOutput io.Writer) error {
+	if deployment.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deployment.Timeout)
+		defer cancel()
+	}
 
-	if err := dockerRun.Start(); err != nil {
+	if err := ctx.Err(); err != nil { // don't even start if already canceled
 		return err
 	}
 
-	return dockerRun.Wait()
-}
-
-func prepareDockerRun(
-	ctx context.Context,
-	deployment Deployment,
-	commandToRun []string,
-) (*exec.Cmd, error) {
-	envsAsDocker := []string{}
-
-	addDockerEnv := func(key string, value string) {
-		envsAsDocker = append(envsAsDocker, "-e", key+"="+value)
+	runner, err := containerRunnerByName(deployment.UserConfig.Runner)
+	if err != nil {
+		return err
 	}
 
-	addDockerEnv("FRIENDLY_REV_ID", deployment.Vam.Version.FriendlyVersion)
-
-	for key, value := range deployment.UserConfig.Envs {
-		addDockerEnv(key, value)
+	run, err := runner.Command(deployment, command, false)
+	if err != nil {
+		return err
 	}
 
-	// needed if tools inside container make excessive use of symlinks, like Terraform:
-	// https://twitter.com/joonas_fi/status/1129316321743855616
-	useShim := true // TODO: make this opt-in?
-
-	workDirMount := "/work"
-	if useShim {
-		workDirMount = shimDirectory
+	wait, err := startWithOutput(run, deployment.Tty, logOutput)
+	if err != nil {
+		return err
 	}
 
-	dockerArgs := append([]string{
-		"docker",
-		"run",
-		"--rm",
-		"-it",
-		"-v", workDir(deployment.UserConfig.ServiceID) + ":" + workDirMount,
-		"-v", stateDir(deployment.UserConfig.ServiceID) + ":/state",
-		"--entrypoint", "", // if image specifies entrypoint, our explicit command would get confused
-		"--workdir", "/work",
-	}, envsAsDocker...)
+	exited := stopContainerOnCancel(ctx, runner, deployment, run)
+	errRun := wait()
+	exited()
 
-	pushDockerArg := func(args ...string) { dockerArgs = append(dockerArgs, args...) }
+	if errRun != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", deployment.Timeout, errRun)
+	}
 
-	if useShim {
-		// bind mount us (the process that is currently running) at /shim, so we can launch
-		// ourselves inside the container for doing the shim dance (copy the work dir) inside container
-		ourExecutable, err := os.Executable()
-		if err != nil {
-			return nil, err
-		}
+	return errRun
+}
 
-		pushDockerArg("-v", ourExecutable+":"+shimBinaryMountPoint)
+// runs plan command. if askApproval, asks whether to proceed with the deploy
+func planAndAskApproval(ctx context.Context, deployment Deployment, askApproval bool) (bool, error) {
+	if len(deployment.ExpandedPlanCommand) == 0 {
+		return false, errors.New("manifest does not declare plan_command")
 	}
 
-	pushDockerArg(deployment.Vam.Manifest.DeployerImage)
+	if err := runInDeployerImage(ctx, deployment, deployment.ExpandedPlanCommand, nil); err != nil {
+		return false, fmt.Errorf("plan: %w", err)
+	}
 
-	if useShim {
-		// NOTE: -- to target argv from being parsed for context of the shim
-		pushDockerArg(shimBinaryMountPoint, "launch-via-shim", "--")
+	if !askApproval {
+		return false, nil
 	}
 
-	// len check so [0] access doesn't fail, though that shouldn't happen
-	useShell := len(commandToRun) > 0 && !strings.HasPrefix(commandToRun[0], "/")
+	approved, err := promptConfirmation(fmt.Sprintf(
+		"Plan done. Deploy command: %s",
+		strings.Join(deployment.ExpandedDeployCommand, " ")))
+	if err != nil {
+		return false, err
+	}
 
-	if useShell {
-		pushDockerArg("/bin/sh", "-c", strings.Join(shellEscape(commandToRun), " "))
-	} else {
-		dockerArgs = append(dockerArgs, commandToRun...)
+	if !approved {
+		log.Println("not approved - skipping deploy")
 	}
 
-	//nolint:gosec // ok
-	return exec.CommandContext(ctx, dockerArgs[0], dockerArgs[1:]...), nil
+	return approved, nil
+}
+
+type deployOptions struct {
+	interactive bool
+	keepCache   bool
+	rollback    bool          // for recording in deployment history
+	plan        bool          // only run plan command
+	approve     bool          // after plan, ask for approval and then deploy
+	unit        string        // overrides unit from user config
+	runner      string        // overrides runner from user config
+	tty         bool          // allocate TTY for the deployer container
+	timeout     time.Duration // overrides manifest's timeout. 0 = use manifest's
 }
 
 func deployInternal(
 	ctx context.Context,
 	serviceId string,
 	releaseId string,
-	asInteractive bool,
-	keepCache bool,
+	opts deployOptions,
 ) error {
-	// we should always start with a blank slate for workdir (state dir is the only one
-	// that can have state)
-	if !keepCache {
-		if err := os.RemoveAll(workDir(serviceId)); err != nil {
-			return err
-		}
-	}
-
 	userConf, err := loadUserConfig(serviceId)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -155,47 +154,133 @@ func deployInternal(
 		}
 	}
 
-	app, err := mkApp(ctx)
+	if opts.unit != "" {
+		userConf.Unit = opts.unit
+	}
+
+	if opts.runner != "" {
+		userConf.Runner = opts.runner
+	}
+
+	app, err := mkAppIfNeeded(ctx, releaseId)
 	if err != nil {
 		return err
 	}
 
+	return deployRelease(ctx, serviceId, releaseId, userConf, app, opts)
+}
+
+func deployRelease(
+	ctx context.Context,
+	serviceId string,
+	releaseId string,
+	userConf *UserConfig,
+	app *dstate.App,
+	opts deployOptions,
+) error {
+	unlock, err := acquireDeploymentLock(serviceId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	deployment, err := prepareDeployment(ctx, serviceId, releaseId, userConf, app, opts.keepCache)
+	if err != nil {
+		return err
+	}
+
+	deployment.Tty = opts.tty
+	if opts.timeout != 0 {
+		deployment.Timeout = opts.timeout
+	}
+
+	if opts.plan {
+		approved, err := planAndAskApproval(ctx, *deployment, opts.approve)
+		if err != nil || !approved {
+			return err
+		}
+
+		// falls through to deploy with the same prepared work dir
+	}
+
+	if _, err := snapshotState(serviceId, "before-"+deployment.ReleaseId, userConf.StateSnapshots); err != nil {
+		return fmt.Errorf("snapshotting state: %w", err)
+	}
+
+	if opts.interactive {
+		if err := interactive(ctx, *deployment); err != nil {
+			return err
+		}
+	} else {
+		if err := recordDeployment(
+			ctx,
+			serviceId,
+			deployment.ReleaseId,
+			userConf.Unit,
+			deployment.Vam.Manifest.SoftwareUniqueId,
+			opts.rollback,
+			app,
+			func() error {
+				return deploy(ctx, *deployment)
+			},
+		); err != nil {
+			return fmt.Errorf("deploy: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// downloads the release into work dir and validates user config against its manifest
+func prepareDeployment(
+	ctx context.Context,
+	serviceId string,
+	releaseId string,
+	userConf *UserConfig,
+	app *dstate.App,
+	keepCache bool,
+) (*Deployment, error) {
+	// we should always start with a blank slate for workdir (state dir is the only one
+	// that can have state)
+	if !keepCache {
+		if err := os.RemoveAll(workDir(serviceId)); err != nil {
+			return nil, err
+		}
+	}
+
 	if releaseId == "" { // automatically resolve latest
 		var err error
 		releaseId, err = resolveLatestReleaseID(userConf.Repository, app)
 
 		if err != nil {
-			return fmt.Errorf("resolve latest release: %w", err)
+			return nil, fmt.Errorf("resolve latest release: %w", err)
 		}
 
 		log.Printf("latest release ID resolved to %s", releaseId)
 	}
 
-	if err := downloadRelease(ctx, serviceId, releaseId, app); err != nil {
-		return fmt.Errorf("downloadRelease: %w", err)
+	if err := downloadRelease(ctx, serviceId, releaseId, userConf.Unit, app, userConf.TrustedSpecKeys); err != nil {
+		return nil, fmt.Errorf("downloadRelease: %w", err)
 	}
 
 	vam, err := loadVersionAndManifest(serviceId)
 	if err != nil {
-		return fmt.Errorf("loadVersionAndManifest: %w", err)
+		return nil, fmt.Errorf("loadVersionAndManifest: %w", err)
 	}
 
-	deployment, err := validateUserConfig(userConf, vam)
+	release, err := resolveRelease(releaseId, app)
 	if err != nil {
-		return fmt.Errorf("validateUserConfig: %w", err)
+		return nil, err
 	}
 
-	if asInteractive {
-		if err := interactive(ctx, *deployment); err != nil {
-			return err
-		}
-	} else {
-		if err := deploy(ctx, *deployment); err != nil {
-			return fmt.Errorf("deploy: %w", err)
-		}
+	deployment, err := validateUserConfig(userConf, vam, release)
+	if err != nil {
+		return nil, fmt.Errorf("validateUserConfig: %w", err)
 	}
 
-	return nil
+	deployment.ReleaseId = releaseId
+
+	return deployment, nil
 }
 
 func resolveLatestReleaseID(repository string, app *dstate.App) (string, error) {
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestManifestTimeout(t *testing.T) {
+	timeout := func(serialized string) string {
+		manifest := DeplSpecManifest{ManifestVersionMajor: 1, Timeout: serialized}
+		if err := validateManifest(&manifest); err != nil {
+			return err.Error()
+		}
+
+		parsed, _ := manifest.parsedTimeout()
+		return parsed.String()
+	}
+
+	assert.EqualString(t, timeout(""), "0s")
+	assert.EqualString(t, timeout("45m"), "45m0s")
+	assert.EqualString(t, timeout("-5m"), "manifest timeout cannot be negative: -5m")
+	// exact message depends on Go version
+	assert.Assert(t, strings.HasPrefix(timeout("forever"), "manifest timeout: time: invalid duration"))
+}
+
+func TestRunInDeployerImageTimeout(t *testing.T) {
+	_, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	assert.Ok(t, os.MkdirAll(workDir("hq"), 0755))
+
+	deployment := testRunnerDeployment()
+	deployment.UserConfig.Runner = "local"
+	deployment.Timeout = 100 * time.Millisecond
+
+	started := time.Now()
+
+	err := runInDeployerImage(context.Background(), deployment, []string{"sleep", "30"}, ioutil.Discard)
+	assert.Assert(t, err != nil)
+	assert.EqualString(t, err.Error(), "timed out after 100ms: signal: terminated")
+
+	// was stopped gracefully, instead of waiting for the command or the kill grace period
+	assert.Assert(t, time.Since(started) < 10*time.Second)
+}
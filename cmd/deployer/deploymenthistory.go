@@ -0,0 +1,222 @@
+package main
+
+// Records deployment outcomes in the event log, so we know which release runs where
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"time"
+
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/dstate"
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/cryptorandombytes"
+	"github.com/function61/gokit/ossignal"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+// wraps the actual deployment in DeploymentStarted and DeploymentSucceeded|DeploymentFailed events
+func recordDeployment(
+	ctx context.Context,
+	serviceId string,
+	releaseId string,
+	unit string,
+	softwareUniqueId string,
+	rollback bool,
+	app *dstate.App,
+	deploy func() error,
+) error {
+	// app is only offline or nil (direct artefacts location without EVENTHORIZON) when
+	// explicitly asked for, because mkAppOrSnapshot() doesn't fall back on connection errors
+	if !canRecordEvents(app) {
+		log.Println("offline mode: deployment will not be recorded in deployment history")
+		return deploy()
+	}
+
+	deploymentId := cryptorandombytes.Base64UrlWithoutLeadingDash(4)
+	operator := currentOperator()
+	started := time.Now()
+
+	if err := appendEvents(ctx, app, ddomain.NewDeploymentStarted(
+		deploymentId,
+		serviceId,
+		releaseId,
+		unit,
+		softwareUniqueId,
+		rollback,
+		ehevent.Meta(started, operator),
+	)); err != nil {
+		return fmt.Errorf("recording deployment start: %w", err)
+	}
+
+	errDeploy := deploy()
+
+	outcome := func() ehevent.Event {
+		meta := ehevent.Meta(time.Now(), operator)
+
+		if errDeploy != nil {
+			return ddomain.NewDeploymentFailed(
+				deploymentId,
+				exitCodeFromErr(errDeploy),
+				time.Since(started),
+				errDeploy.Error(),
+				meta)
+		} else {
+			return ddomain.NewDeploymentSucceeded(deploymentId, time.Since(started), meta)
+		}
+	}()
+
+	// outcome must be recorded even if the deployment was canceled
+	if err := appendEvents(context.Background(), app, outcome); err != nil {
+		if errDeploy != nil {
+			return fmt.Errorf("%w (also failed recording outcome: %v)", errDeploy, err)
+		}
+
+		return fmt.Errorf("deployment succeeded but recording outcome failed: %w", err)
+	}
+
+	return errDeploy
+}
+
+func appendEvents(ctx context.Context, app *dstate.App, events ...ehevent.Event) error {
+	if !canRecordEvents(app) {
+		return errors.New("cannot record events in offline mode")
+	}
+
+	serialized := []string{}
+	for _, event := range events {
+		serialized = append(serialized, ehevent.Serialize(event))
+	}
+
+	_, err := app.Writer.Append(
+		ctx,
+		app.TenantCtx.Tenant.Stream(dstate.Stream),
+		serialized)
+	return err
+}
+
+// -1 if the process didn't exit by itself (or didn't even get to start)
+func exitCodeFromErr(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func currentOperator() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+
+	if fromEnv := os.Getenv("USER"); fromEnv != "" {
+		return fromEnv
+	}
+
+	return "unknown"
+}
+
+func deploymentsEntry(logger *log.Logger) *cobra.Command {
+	return &cobra.Command{
+		Use:   "deployments [serviceId]",
+		Short: "Show deployment history of a service",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(listDeployments(
+				ossignal.InterruptOrTerminateBackgroundCtx(logger),
+				args[0]))
+		},
+	}
+}
+
+func listDeployments(ctx context.Context, serviceId string) error {
+	app, err := mkAppOrSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	deploymentsTbl := termtables.CreateTable()
+	deploymentsTbl.AddHeaders("Started", "Release", "Kind", "Status", "Exit code", "Duration", "Operator")
+
+	for _, deployment := range app.State.DeploymentsNewestFirst(serviceId) {
+		kind := "deploy"
+		switch {
+		case deployment.Rollback:
+			kind = "rollback"
+		case deployment.Status == dstate.DeploymentStatusDestroyed:
+			kind = "destroy"
+		}
+
+		release := deployment.ReleaseId
+		if deployment.Unit != "" {
+			release += " (" + deployment.Unit + ")"
+		}
+
+		deploymentsTbl.AddRow(
+			deployment.Started.Local().Format("Jan 02 @ 15:04"),
+			release,
+			kind,
+			string(deployment.Status),
+			deployment.ExitCode,
+			deployment.Duration.Round(time.Second).String(),
+			deployment.Operator)
+	}
+
+	fmt.Println(deploymentsTbl.Render())
+
+	if current, err := app.State.CurrentDeployment(serviceId); err == nil {
+		fmt.Printf(
+			"Currently deployed: %s (since %s)\n",
+			current.ReleaseId,
+			current.Started.Local().Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// redeploys the release that was successfully deployed before the current one
+func rollback(ctx context.Context, serviceId string) error {
+	userConf, err := loadUserConfig(serviceId)
+	if err != nil {
+		return err
+	}
+
+	app, err := mkAppOrSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+
+	previous, err := app.State.PreviousDeployment(serviceId)
+	if err != nil {
+		return err
+	}
+
+	// validateUserConfig() would catch this too, but only after downloading the release
+	if previous.SoftwareUniqueId != "" && previous.SoftwareUniqueId != userConf.SoftwareUniqueId {
+		return fmt.Errorf(
+			"refusing to roll back to %s: software ID mismatch; deploymentConfig(%s) != previousDeployment(%s)",
+			previous.ReleaseId,
+			userConf.SoftwareUniqueId,
+			previous.SoftwareUniqueId)
+	}
+
+	log.Printf(
+		"rolling back to %s (deployed %s by %s)",
+		previous.ReleaseId,
+		previous.Started.Local().Format(time.RFC3339),
+		previous.Operator)
+
+	userConf.Unit = previous.Unit
+
+	return deployRelease(ctx, serviceId, previous.ReleaseId, userConf, app, deployOptions{
+		rollback: true,
+		tty:      stdinIsTerminal(),
+	})
+}
@@ -0,0 +1,162 @@
+package main
+
+// Exclusive per-service lock, because concurrent deployments of the same service would
+// wipe each others' work dir and write into the same state dir (= corrupted Terraform state)
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/function61/gokit/jsonfile"
+	"github.com/spf13/cobra"
+)
+
+type deploymentLockHolder struct {
+	Operator string    `json:"operator"`
+	Pid      int       `json:"pid"`
+	Host     string    `json:"host"`
+	Started  time.Time `json:"started"`
+}
+
+func (d deploymentLockHolder) String() string {
+	return fmt.Sprintf(
+		"%s (PID %d on %s, since %s)",
+		d.Operator,
+		d.Pid,
+		d.Host,
+		d.Started.Local().Format(time.RFC3339))
+}
+
+func deploymentLockPath(serviceId string) string {
+	return deploymentDir(serviceId) + "/deploy.lock"
+}
+
+// call the returned function to release the lock (safe to call more than once)
+func acquireDeploymentLock(serviceId string) (func(), error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	lockPath := deploymentLockPath(serviceId)
+
+	// O_EXCL makes creation atomic: only one of concurrent deployers can succeed
+	lockFile, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, deploymentLockedErr(serviceId)
+		}
+
+		return nil, err
+	}
+
+	if err := func() error {
+		defer lockFile.Close()
+
+		return json.NewEncoder(lockFile).Encode(&deploymentLockHolder{
+			Operator: currentOperator(),
+			Pid:      os.Getpid(),
+			Host:     hostname,
+			Started:  time.Now().UTC(),
+		})
+	}(); err != nil {
+		_ = os.Remove(lockPath)
+		return nil, fmt.Errorf("writing lock file: %w", err)
+	}
+
+	released := false
+
+	return func() {
+		if released { // don't remove a lock someone else acquired after us
+			return
+		}
+		released = true
+
+		if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("WARN: failed to release deployment lock: %v", err)
+		}
+	}, nil
+}
+
+func deploymentLockedErr(serviceId string) error {
+	holder, err := readDeploymentLock(serviceId)
+	if err != nil {
+		return fmt.Errorf("deployment %s is locked, but reading lock holder failed: %w", serviceId, err)
+	}
+
+	return fmt.Errorf(
+		"deployment %s is locked by %s\nIf the lock is stale, run:\n\t$ %s unlock %s",
+		serviceId,
+		holder.String(),
+		os.Args[0],
+		serviceId)
+}
+
+func readDeploymentLock(serviceId string) (*deploymentLockHolder, error) {
+	holder := &deploymentLockHolder{}
+	return holder, jsonfile.Read(deploymentLockPath(serviceId), holder, true)
+}
+
+func unlockEntry() *cobra.Command {
+	force := false
+
+	cmd := &cobra.Command{
+		Use:   "unlock [serviceId]",
+		Short: "Removes a stale deployment lock",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(unlock(args[0], force))
+		},
+	}
+
+	cmd.Flags().BoolVarP(&force, "force", "", force, "Remove lock even if the holder process seems to be running")
+
+	return cmd
+}
+
+func unlock(serviceId string, force bool) error {
+	holder, err := readDeploymentLock(serviceId)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("deployment %s is not locked", serviceId)
+		}
+
+		return err
+	}
+
+	if !force && holderRunning(*holder) {
+		return fmt.Errorf(
+			"lock holder %s is still running. use --force if you're sure",
+			holder.String())
+	}
+
+	if err := os.Remove(deploymentLockPath(serviceId)); err != nil {
+		return err
+	}
+
+	log.Printf("removed lock held by %s", holder.String())
+
+	return nil
+}
+
+// only knowable if holder is on this host
+func holderRunning(holder deploymentLockHolder) bool {
+	hostname, err := os.Hostname()
+	if err != nil || hostname != holder.Host {
+		return false
+	}
+
+	process, err := os.FindProcess(holder.Pid)
+	if err != nil {
+		return false
+	}
+
+	// signal 0 only checks for existence. EPERM means it exists but is someone else's
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestDeploymentLock(t *testing.T) {
+	_, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	assert.Ok(t, os.MkdirAll(deploymentDir("hq"), 0755))
+
+	unlockFirst, err := acquireDeploymentLock("hq")
+	assert.Ok(t, err)
+
+	holder, err := readDeploymentLock("hq")
+	assert.Ok(t, err)
+	assert.Assert(t, holder.Pid == os.Getpid())
+
+	_, err = acquireDeploymentLock("hq")
+	assert.EqualString(t, err.Error(), fmt.Sprintf(
+		"deployment hq is locked by %s\nIf the lock is stale, run:\n\t$ %s unlock hq",
+		holder.String(),
+		os.Args[0]))
+
+	// we're the holder and we're running
+	assert.EqualString(t, unlock("hq", false).Error(), fmt.Sprintf(
+		"lock holder %s is still running. use --force if you're sure",
+		holder.String()))
+
+	unlockFirst()
+
+	unlockSecond, err := acquireDeploymentLock("hq")
+	assert.Ok(t, err)
+
+	unlockFirst() // must not release second holder's lock
+
+	_, err = readDeploymentLock("hq")
+	assert.Ok(t, err)
+
+	assert.Ok(t, unlock("hq", true))
+	assert.EqualString(t, unlock("hq", false).Error(), "deployment hq is not locked")
+
+	unlockSecond()
+}
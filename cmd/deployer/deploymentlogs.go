@@ -0,0 +1,212 @@
+package main
+
+// Output of each deploy run is stored in the deployment dir, so we can later see what
+// Terraform etc. did even if the terminal is long gone:
+//   deployments/<serviceId>/logs/<timestamp>-<releaseId>.log
+//   deployments/<serviceId>/logs/<timestamp>-<releaseId>.json (metadata, written when done)
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/jsonfile"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+type deploymentLogMeta struct {
+	ReleaseId string        `json:"release_id"`
+	Unit      string        `json:"unit,omitempty"`
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	ExitCode  int           `json:"exit_code"` // -1 if deployment didn't get to run
+	Operator  string        `json:"operator"`
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// "20200220_140200-id1". label is usually release ID, which can be a direct artefacts
+// location (= URL), so it has to be made filename-safe. sorts chronologically.
+func timestampedId(started time.Time, label string) string {
+	labelSafe := strings.Trim(unsafeFilenameChars.ReplaceAllString(label, "_"), "_")
+
+	const maxLen = 64
+	if len(labelSafe) > maxLen {
+		labelSafe = labelSafe[:maxLen]
+	}
+
+	return started.UTC().Format("20060102_150405") + "-" + labelSafe
+}
+
+func deploymentLogPath(serviceId string, logId string) string {
+	return filepath.Join(logsDir(serviceId), logId+".log")
+}
+
+func deploymentLogMetaPath(serviceId string, logId string) string {
+	return filepath.Join(logsDir(serviceId), logId+".json")
+}
+
+// tees run's output into a log file and records its outcome in metadata file
+func runWithDeploymentLog(deployment Deployment, run func(logOutput io.Writer) error) error {
+	serviceId := deployment.UserConfig.ServiceID
+	started := time.Now()
+	logId := timestampedId(started, deployment.ReleaseId)
+
+	if err := os.MkdirAll(logsDir(serviceId), 0700); err != nil {
+		return err
+	}
+
+	logFile, err := os.Create(deploymentLogPath(serviceId, logId))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	errRun := run(logFile)
+
+	exitCode := 0
+	if errRun != nil {
+		exitCode = exitCodeFromErr(errRun)
+	}
+
+	if err := jsonfile.Write(deploymentLogMetaPath(serviceId, logId), &deploymentLogMeta{
+		ReleaseId: deployment.ReleaseId,
+		Unit:      deployment.UserConfig.Unit,
+		Started:   started.UTC(),
+		Duration:  time.Since(started),
+		ExitCode:  exitCode,
+		Operator:  currentOperator(),
+	}); err != nil {
+		if errRun != nil {
+			return fmt.Errorf("%w (also failed writing log metadata: %v)", errRun, err)
+		}
+
+		return fmt.Errorf("writing log metadata: %w", err)
+	}
+
+	log.Printf("deployment log: %s", deploymentLogPath(serviceId, logId))
+
+	return errRun
+}
+
+func logsEntry() *cobra.Command {
+	return &cobra.Command{
+		Use:   "logs [serviceId] [logId]",
+		Short: "List deployment logs of a service, or show a specific log",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(_ *cobra.Command, args []string) {
+			if len(args) == 2 {
+				exitWithErrorIfErr(showDeploymentLog(args[0], args[1], os.Stdout))
+			} else {
+				exitWithErrorIfErr(listDeploymentLogs(args[0]))
+			}
+		},
+	}
+}
+
+func listDeploymentLogs(serviceId string) error {
+	logIds, err := deploymentLogIdsNewestFirst(serviceId)
+	if err != nil {
+		return err
+	}
+
+	logsTbl := termtables.CreateTable()
+	logsTbl.AddHeaders("Log ID", "Started", "Release", "Exit code", "Duration", "Operator")
+
+	for _, logId := range logIds {
+		meta := deploymentLogMeta{}
+		if err := jsonfile.Read(deploymentLogMetaPath(serviceId, logId), &meta, true); err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+
+			// deployer didn't get to record the outcome (still running or crashed)
+			logsTbl.AddRow(logId, "", "", "?", "", "")
+			continue
+		}
+
+		release := meta.ReleaseId
+		if meta.Unit != "" {
+			release += " (" + meta.Unit + ")"
+		}
+
+		logsTbl.AddRow(
+			logId,
+			meta.Started.Local().Format("Jan 02 @ 15:04"),
+			release,
+			meta.ExitCode,
+			meta.Duration.Round(time.Second).String(),
+			meta.Operator)
+	}
+
+	fmt.Println(logsTbl.Render())
+
+	return nil
+}
+
+func showDeploymentLog(serviceId string, logId string, output io.Writer) error {
+	logFile, err := os.Open(deploymentLogPath(serviceId, filepath.Base(logId)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("log not found: %s (list logs with $ %s logs %s)", logId, os.Args[0], serviceId)
+		}
+
+		return err
+	}
+	defer logFile.Close()
+
+	_, err = io.Copy(output, logFile)
+	return err
+}
+
+func deploymentLogIdsNewestFirst(serviceId string) ([]string, error) {
+	return timestampedIdsNewestFirst(logsDir(serviceId), ".log")
+}
+
+// IDs of files in dir named "<timestampedId><extension>"
+func timestampedIdsNewestFirst(dir string, extension string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+
+		return nil, err
+	}
+
+	matching := []os.FileInfo{}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), extension) {
+			matching = append(matching, file)
+		}
+	}
+
+	// IDs start with timestamp, but with only second precision. mtime orders files created
+	// within the same second.
+	timestamp := func(file os.FileInfo) string {
+		return strings.SplitN(file.Name(), "-", 2)[0]
+	}
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		if timestamp(matching[i]) != timestamp(matching[j]) {
+			return timestamp(matching[i]) > timestamp(matching[j])
+		}
+
+		return matching[i].ModTime().After(matching[j].ModTime())
+	})
+
+	ids := []string{}
+	for _, file := range matching {
+		ids = append(ids, strings.TrimSuffix(file.Name(), extension))
+	}
+
+	return ids, nil
+}
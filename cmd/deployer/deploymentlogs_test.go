@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+	"github.com/function61/gokit/jsonfile"
+)
+
+func TestTimestampedId(t *testing.T) {
+	started := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	assert.EqualString(t, timestampedId(started, "id1"), "20200220_140200-id1")
+	assert.EqualString(t, timestampedId(started, "https://example.com/dl/#deployerspec.zip"), "20200220_140200-https_example.com_dl_deployerspec.zip")
+}
+
+func TestRunWithDeploymentLog(t *testing.T) {
+	_, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	deployment := Deployment{
+		UserConfig: UserConfig{ServiceID: "hq"},
+		ReleaseId:  "id1",
+	}
+
+	err := runWithDeploymentLog(deployment, func(logOutput io.Writer) error {
+		fmt.Fprintln(logOutput, "terraform apply")
+		return exec.Command("sh", "-c", "exit 3").Run()
+	})
+	assert.EqualString(t, err.Error(), "exit status 3")
+
+	logIds, err := deploymentLogIdsNewestFirst("hq")
+	assert.Ok(t, err)
+	assert.Assert(t, len(logIds) == 1)
+
+	meta := deploymentLogMeta{}
+	assert.Ok(t, jsonfile.Read(deploymentLogMetaPath("hq", logIds[0]), &meta, true))
+	assert.EqualString(t, meta.ReleaseId, "id1")
+	assert.Assert(t, meta.ExitCode == 3)
+
+	logContent := &bytes.Buffer{}
+	assert.Ok(t, showDeploymentLog("hq", logIds[0], logContent))
+	assert.EqualString(t, logContent.String(), "terraform apply\n")
+
+	assert.EqualString(
+		t,
+		showDeploymentLog("hq", "nonexistent", logContent).Error(),
+		fmt.Sprintf("log not found: nonexistent (list logs with $ %s logs hq)", os.Args[0]))
+}
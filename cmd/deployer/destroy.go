@@ -0,0 +1,200 @@
+package main
+
+// Tears down resources used by a service with the manifest-declared destroy command
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/dirarchive"
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/atomicfilewrite"
+	"github.com/function61/gokit/ossignal"
+	"github.com/spf13/cobra"
+)
+
+func destroyEntry(logger *log.Logger) *cobra.Command {
+	releaseId := ""
+	archive := false
+	assumeYes := false
+	runner := ""
+	tty := ttyFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "destroy [serviceId]",
+		Short: "Destroys all resources used by service",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(func() error {
+				allocateTty, err := tty.resolve()
+				if err != nil {
+					return err
+				}
+
+				return destroy(
+					ossignal.InterruptOrTerminateBackgroundCtx(logger),
+					args[0],
+					releaseId,
+					archive,
+					assumeYes,
+					runner,
+					allocateTty)
+			}())
+		},
+	}
+
+	cmd.Flags().StringVarP(&releaseId, "release", "", releaseId, "Release whose destroy command to use (default: currently deployed)")
+	cmd.Flags().BoolVarP(&archive, "archive", "", archive, "Archive and remove the deployment directory after destroying")
+	cmd.Flags().BoolVarP(&assumeYes, "yes", "y", assumeYes, "Don't ask for confirmation")
+	cmd.Flags().StringVarP(&runner, "runner", "", runner, runnerFlagUsage)
+	tty.register(cmd)
+
+	return cmd
+}
+
+func destroy(
+	ctx context.Context,
+	serviceId string,
+	releaseId string,
+	archive bool,
+	assumeYes bool,
+	runner string, // overrides runner from user config
+	tty bool,
+) error {
+	userConf, err := loadUserConfig(serviceId)
+	if err != nil {
+		return err
+	}
+
+	if runner != "" {
+		userConf.Runner = runner
+	}
+
+	unlock, err := acquireDeploymentLock(serviceId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	app, err := mkAppIfNeeded(ctx, releaseId)
+	if err != nil {
+		return err
+	}
+
+	if releaseId == "" {
+		current, err := app.State.CurrentDeployment(serviceId)
+		if err != nil {
+			return fmt.Errorf("%w\nPro-tip: specify release with --release", err)
+		}
+
+		releaseId = current.ReleaseId
+		userConf.Unit = current.Unit
+	}
+
+	deployment, err := prepareDeployment(ctx, serviceId, releaseId, userConf, app, false)
+	if err != nil {
+		return err
+	}
+
+	deployment.Tty = tty
+
+	if len(deployment.ExpandedDestroyCommand) == 0 {
+		return fmt.Errorf("manifest of release %s does not declare destroy_command", releaseId)
+	}
+
+	if !assumeYes {
+		confirmed, err := promptConfirmation(fmt.Sprintf(
+			"Destroy all resources of %s with: %s",
+			serviceId,
+			strings.Join(deployment.ExpandedDestroyCommand, " ")))
+		if err != nil {
+			return err
+		}
+
+		if !confirmed {
+			return errors.New("destroy canceled")
+		}
+	}
+
+	if _, err := snapshotState(serviceId, "before-destroy", userConf.StateSnapshots); err != nil {
+		return fmt.Errorf("snapshotting state: %w", err)
+	}
+
+	if err := runInDeployerImage(ctx, *deployment, deployment.ExpandedDestroyCommand, nil); err != nil {
+		return fmt.Errorf("destroy: %w", err)
+	}
+
+	if canRecordEvents(app) {
+		if err := appendEvents(ctx, app, ddomain.NewServiceDestroyed(
+			serviceId,
+			releaseId,
+			ehevent.Meta(time.Now(), currentOperator()),
+		)); err != nil {
+			return fmt.Errorf("resources destroyed but recording it failed: %w", err)
+		}
+	} else {
+		log.Println("offline mode: destroy will not be recorded in deployment history")
+	}
+
+	if archive {
+		// lock is held until the dir is gone, so a deploy can't start in the dir meanwhile
+		archivePath, err := archiveAndRemoveDeploymentDir(serviceId)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("deployment dir archived to %s", archivePath)
+	}
+
+	return nil
+}
+
+// state dir (or user config) could be needed for forensics later, so don't just delete it
+func archiveAndRemoveDeploymentDir(serviceId string) (string, error) {
+	archivePath := fmt.Sprintf(
+		"%s.destroyed-%s.tar.gz",
+		deploymentDir(serviceId),
+		time.Now().UTC().Format("20060102_1504"))
+
+	if err := atomicfilewrite.Write(archivePath, func(archive io.Writer) error {
+		gzipWriter := gzip.NewWriter(archive)
+
+		// our own lock is of no use later
+		if err := dirarchive.Create(gzipWriter, deploymentDir(serviceId), filepath.Base(deploymentLockPath(serviceId))); err != nil {
+			return err
+		}
+
+		return gzipWriter.Close()
+	}); err != nil {
+		return "", fmt.Errorf("archiveAndRemoveDeploymentDir: %w", err)
+	}
+
+	return archivePath, os.RemoveAll(deploymentDir(serviceId))
+}
+
+// asks an y/N question from the operator
+func promptConfirmation(question string) (bool, error) {
+	fmt.Printf("%s\nContinue? [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
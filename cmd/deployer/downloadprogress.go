@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/function61/gokit/atomicfilewrite"
+)
+
+// reports per-file byte progress and total throughput of concurrent downloads. on a TTY
+// renders a continuously updating status line, otherwise logs when each file completes.
+type downloadProgress struct {
+	mu            sync.Mutex
+	files         []*fileProgress // in order of starting
+	expectedSizes map[string]int64
+	started       time.Time
+	output        *os.File
+	isTerminal    bool
+}
+
+type fileProgress struct {
+	filename string
+	expected int64 // 0 if unknown
+	received int64 // atomic
+	started  time.Time
+	finished bool
+}
+
+func newDownloadProgress(output *os.File) *downloadProgress {
+	return &downloadProgress{
+		files:         []*fileProgress{},
+		expectedSizes: map[string]int64{},
+		started:       time.Now(),
+		output:        output,
+		isTerminal:    isTerminal(output),
+	}
+}
+
+// sizes are used for showing percentages. known sizes come from checksums.
+func (d *downloadProgress) SetExpectedSize(filename string, size int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expectedSizes[filename] = size
+}
+
+func (d *downloadProgress) Track(filename string, content io.ReadCloser) io.ReadCloser {
+	return &progressReader{content, d.start(filename), d}
+}
+
+func (d *downloadProgress) start(filename string) *fileProgress {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	file := &fileProgress{
+		filename: filename,
+		expected: d.expectedSizes[filename],
+		started:  time.Now(),
+	}
+
+	d.files = append(d.files, file)
+
+	return file
+}
+
+// renders status line until ctx is canceled. no-op if not on a TTY. meanwhile log output
+// goes through us, so log lines and the status line don't garble each other.
+func (d *downloadProgress) Render(ctx context.Context) {
+	if !d.isTerminal {
+		return
+	}
+
+	logOutput := log.Writer()
+	log.SetOutput(&statusLineClearingWriter{logOutput, d})
+	defer log.SetOutput(logOutput)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.renderStatusLine()
+			fmt.Fprintln(d.output)
+			return
+		case <-ticker.C:
+			d.renderStatusLine()
+		}
+	}
+}
+
+func (d *downloadProgress) renderStatusLine() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.renderStatusLineLocked()
+}
+
+func (d *downloadProgress) renderStatusLineLocked() {
+	if len(d.files) == 0 {
+		return
+	}
+
+	total := int64(0)
+	inProgress := []string{}
+
+	for _, file := range d.files {
+		received := atomic.LoadInt64(&file.received)
+		total += received
+
+		if file.finished {
+			continue
+		}
+
+		if file.expected > 0 {
+			inProgress = append(inProgress, fmt.Sprintf(
+				"%s %s/%s (%d %%)",
+				file.filename,
+				humanizeBytes(received),
+				humanizeBytes(file.expected),
+				received*100/file.expected))
+		} else {
+			inProgress = append(inProgress, fmt.Sprintf("%s %s", file.filename, humanizeBytes(received)))
+		}
+	}
+
+	fmt.Fprintf(
+		d.output,
+		clearLine+"%s | %s",
+		strings.Join(inProgress, ", "),
+		throughput(total, time.Since(d.started)))
+}
+
+// go to line start and clear line
+const clearLine = "\r\033[K"
+
+// clears the status line before writing and redraws it after
+type statusLineClearingWriter struct {
+	inner    io.Writer
+	progress *downloadProgress
+}
+
+func (s *statusLineClearingWriter) Write(buf []byte) (int, error) {
+	// (progress doesn't log while holding the lock when on a TTY, so this can't deadlock)
+	s.progress.mu.Lock()
+	defer s.progress.mu.Unlock()
+
+	fmt.Fprint(s.progress.output, clearLine)
+
+	n, err := s.inner.Write(buf)
+
+	s.progress.renderStatusLineLocked()
+
+	return n, err
+}
+
+func (d *downloadProgress) finished(file *fileProgress) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if file.finished {
+		return
+	}
+	file.finished = true
+
+	if !d.isTerminal {
+		received := atomic.LoadInt64(&file.received)
+		duration := time.Since(file.started)
+
+		log.Printf(
+			"downloaded %s (%s in %s, %s)",
+			file.filename,
+			humanizeBytes(received),
+			duration.Round(time.Millisecond),
+			throughput(received, duration))
+	}
+}
+
+// counts written bytes as received
+func (f *fileProgress) Write(buf []byte) (int, error) {
+	atomic.AddInt64(&f.received, int64(len(buf)))
+	return len(buf), nil
+}
+
+type progressReader struct {
+	io.ReadCloser
+	file     *fileProgress
+	progress *downloadProgress
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.ReadCloser.Read(buf)
+	atomic.AddInt64(&p.file.received, int64(n))
+
+	if err == io.EOF {
+		p.progress.finished(p.file)
+	}
+
+	return n, err
+}
+
+// wraps artefactDownloader so each download reports its progress
+type progressArtefactDownloader struct {
+	inner    artefactDownloader
+	progress *downloadProgress
+}
+
+func (p *progressArtefactDownloader) DownloadArtefact(ctx context.Context, filename string) (io.ReadCloser, error) {
+	content, err := p.inner.DownloadArtefact(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.progress.Track(filename, content), nil
+}
+
+// so wrapping doesn't hide resume support from the cache. falls back to DownloadArtefact()
+// if inner can't download to file.
+func (p *progressArtefactDownloader) DownloadArtefactToFile(
+	ctx context.Context,
+	filename string,
+	destination string,
+	progress io.Writer,
+) error {
+	fileDownloader, ok := p.inner.(artefactFileDownloader)
+	if !ok {
+		content, err := p.DownloadArtefact(ctx, filename)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+
+		return atomicfilewrite.Write(destination, func(sink io.Writer) error {
+			if progress != nil {
+				sink = io.MultiWriter(sink, progress)
+			}
+
+			_, err := io.Copy(sink, content)
+			return err
+		})
+	}
+
+	file := p.progress.start(filename)
+
+	writers := []io.Writer{file}
+	if progress != nil {
+		writers = append(writers, progress)
+	}
+
+	if err := fileDownloader.DownloadArtefactToFile(ctx, filename, destination, io.MultiWriter(writers...)); err != nil {
+		return err
+	}
+
+	p.progress.finished(file)
+
+	return nil
+}
+
+// so wrapping doesn't hide content digests from the cache
+func (p *progressArtefactDownloader) ArtefactDigest(filename string) (string, bool) {
+	if digester, ok := p.inner.(artefactDigester); ok {
+		return digester.ArtefactDigest(filename)
+	}
+
+	return "", false
+}
+
+func throughput(bytes int64, duration time.Duration) string {
+	if duration <= 0 {
+		return "- /s"
+	}
+
+	return humanizeBytes(int64(float64(bytes)/duration.Seconds())) + "/s"
+}
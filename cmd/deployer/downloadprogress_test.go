@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestLogLinesDontGarbleStatusLine(t *testing.T) {
+	output, err := ioutil.TempFile("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	progress := newDownloadProgress(output)
+	progress.isTerminal = true // temp file isn't one
+
+	progress.Track("app.tar.gz", ioutil.NopCloser(strings.NewReader("")))
+
+	log.SetOutput(output)
+	defer log.SetOutput(os.Stderr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	renderDone := make(chan interface{})
+	go func() {
+		defer close(renderDone)
+		progress.Render(ctx)
+	}()
+
+	for log.Writer() == output { // wait for Render() to take over log output
+		time.Sleep(time.Millisecond)
+	}
+
+	log.Println("downloading app.tar.gz")
+
+	cancel()
+	<-renderDone
+
+	assert.Assert(t, log.Writer() == output)
+
+	written, err := ioutil.ReadFile(output.Name())
+	assert.Ok(t, err)
+
+	assert.Assert(t, regexp.MustCompile(`\r\x1b\[K[^\r]*downloading app\.tar\.gz\n\r\x1b\[Kapp\.tar\.gz 0 B \| `).Match(written))
+}
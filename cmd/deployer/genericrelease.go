@@ -0,0 +1,170 @@
+package main
+
+// Registers release from any artefacts location that we can download from
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/dstate"
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/cryptorandombytes"
+	"github.com/function61/gokit/ossignal"
+	"github.com/spf13/cobra"
+)
+
+func createReleaseEntry(logger *log.Logger) *cobra.Command {
+	units := releaseUnitFlags{}
+
+	cmd := &cobra.Command{
+		Use:   "mk [artefactsLocation] [repository] [releaseName] [revisionId]",
+		Short: "Create release from artefacts location (https://, s3://, file:)",
+		Args:  cobra.ExactArgs(4),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(func() error {
+				deployerSpecFilename, otherUnits, err := units.parse()
+				if err != nil {
+					return err
+				}
+
+				return createRelease(
+					ossignal.InterruptOrTerminateBackgroundCtx(logger),
+					args[0],
+					deployerSpecFilename,
+					otherUnits,
+					args[1],
+					args[2],
+					args[3],
+					logger,
+				)
+			}())
+		},
+	}
+
+	units.register(cmd)
+
+	return cmd
+}
+
+func createRelease(
+	ctx context.Context,
+	artefactsLocation string,
+	deployerSpecFilename string,
+	units map[string]string, // other units than main
+	repository string,
+	releaseName string,
+	revisionId string,
+	logger *log.Logger,
+) error {
+	if err := validateGenericArtefactsLocation(artefactsLocation); err != nil {
+		return err
+	}
+
+	app, err := mkApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	return createReleaseIn(
+		ctx,
+		app,
+		artefactsLocation,
+		deployerSpecFilename,
+		units,
+		repository,
+		releaseName,
+		revisionId,
+		logger)
+}
+
+func createReleaseIn(
+	ctx context.Context,
+	app *dstate.App,
+	artefactsLocation string,
+	deployerSpecFilename string,
+	units map[string]string, // other units than main
+	repository string,
+	releaseName string,
+	revisionId string,
+	logger *log.Logger,
+) error {
+	if app.State.HasRevisionId(revisionId) { // should not be considered an error
+		logger.Printf("WARN: already have revision %s", revisionId)
+		return nil
+	}
+
+	specFilenames := []string{deployerSpecFilename}
+	for _, unitSpecFilename := range units {
+		specFilenames = append(specFilenames, unitSpecFilename)
+	}
+
+	// validate before registering, because a release that can't be deployed is worse than none
+	checksums := map[string]ddomain.ArtefactChecksum{}
+	for _, specFilename := range specFilenames {
+		specChecksum, err := validateDeployerSpecAt(ctx, artefactsLocation, specFilename)
+		if err != nil {
+			return fmt.Errorf("validating %s: %w", specFilename, err)
+		}
+
+		checksums[specFilename] = *specChecksum
+	}
+
+	releaseCreated := ddomain.NewReleaseCreated(
+		cryptorandombytes.Base64UrlWithoutLeadingDash(4),
+		repository,
+		releaseName,
+		revisionId,
+		artefactsLocation,
+		deployerSpecFilename,
+		units,
+		checksums,
+		ehevent.MetaSystemUser(time.Now()))
+
+	return appendEvents(ctx, app, releaseCreated)
+}
+
+// release hosts and images have their own commands because they need more than a location
+func validateGenericArtefactsLocation(artefactsLocation string) error {
+	for _, supported := range []string{"file:", "http:", "https:", s3Scheme} {
+		if strings.HasPrefix(artefactsLocation, supported) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unsupported artefacts location for releases mk: %s", artefactsLocation)
+}
+
+// downloads deployer spec and checks that its manifest parses. returns spec's checksum
+func validateDeployerSpecAt(
+	ctx context.Context,
+	artefactsLocation string,
+	deployerSpecFilename string,
+) (*ddomain.ArtefactChecksum, error) {
+	artefacts, err := makeArtefactDownloader(ctx, artefactsLocation, credentialsProvider())
+	if err != nil {
+		return nil, err
+	}
+
+	deployerSpecReader, err := artefacts.DownloadArtefact(ctx, deployerSpecFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer deployerSpecReader.Close()
+
+	deployerSpec := &bytes.Buffer{}
+	if _, err := io.Copy(deployerSpec, deployerSpecReader); err != nil {
+		return nil, err
+	}
+
+	if _, err := readAndValidateManifestFromSpec(deployerSpec.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return checksumOfReader(bytes.NewReader(deployerSpec.Bytes()))
+}
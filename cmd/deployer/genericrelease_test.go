@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/deployer/pkg/dstate"
+	"github.com/function61/eventhorizon/pkg/ehreader"
+	"github.com/function61/eventhorizon/pkg/ehreader/ehreadertest"
+	"github.com/function61/gokit/assert"
+)
+
+func TestValidateDeployerSpecAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	writeSpec := func(filename string, manifestJson string) {
+		t.Helper()
+
+		file, err := os.Create(filepath.Join(dir, filename))
+		assert.Ok(t, err)
+		defer file.Close()
+
+		zipWriter := zip.NewWriter(file)
+
+		manifest, err := zipWriter.Create("manifest.json")
+		assert.Ok(t, err)
+
+		_, err = manifest.Write([]byte(manifestJson))
+		assert.Ok(t, err)
+
+		assert.Ok(t, zipWriter.Close())
+	}
+
+	writeSpec("good.zip", `{"manifest_version_major": 1, "deployer_image": "alpine"}`)
+	writeSpec("badversion.zip", `{"manifest_version_major": 2}`)
+	writeSpec("typo.zip", `{"manifest_version_major": 1, "deployer_imag": "alpine"}`)
+
+	validate := func(filename string) string {
+		checksum, err := validateDeployerSpecAt(context.TODO(), "file:"+dir, filename)
+		if err != nil {
+			return err.Error()
+		}
+
+		assert.Assert(t, checksum.Size > 0)
+
+		return "ok"
+	}
+
+	assert.EqualString(t, validate("good.zip"), "ok")
+	assert.EqualString(t, validate("badversion.zip"), "unsupported manifest version; got 2")
+	assert.EqualString(t, validate("typo.zip"), `manifest.json: JSON parsing failed: json: unknown field "deployer_imag"`)
+	assert.EqualString(t, validate("notfound.zip"), "open "+dir+"/notfound.zip: no such file or directory")
+}
+
+func TestValidateGenericArtefactsLocation(t *testing.T) {
+	assert.Ok(t, validateGenericArtefactsLocation("https://dl.example.com/coolproduct/1.2.3/"))
+	assert.Ok(t, validateGenericArtefactsLocation("s3://bucket/coolproduct/1.2.3/"))
+
+	assert.EqualString(
+		t,
+		validateGenericArtefactsLocation("githubrelease:function61:coolproduct:123").Error(),
+		"unsupported artefacts location for releases mk: githubrelease:function61:coolproduct:123")
+}
+
+func TestCreateReleaseThenDownload(t *testing.T) {
+	ctx := context.Background()
+
+	dir, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	artefactsDir := filepath.Join(dir, "artefacts")
+	assert.Ok(t, os.Mkdir(artefactsDir, 0755))
+
+	// spec's version.json has no checksums, so release only knows the spec's checksum
+	writeZip(t, filepath.Join(artefactsDir, "deployerspec.zip"), map[string]string{
+		"manifest.json": `{"manifest_version_major": 1, "deployer_image": "alpine", "download_artefacts": ["app.tar.gz"]}`,
+		"version.json":  `{"friendly_version": "v1"}`,
+	})
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(artefactsDir, "app.tar.gz"), []byte("app"), 0644))
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE("/t-42" + dstate.Stream) // in-memory log requires the stream to exist
+
+	app, err := dstate.LoadUntilRealtime(
+		ctx,
+		ehreader.NewTenantCtx(ehreader.TenantId("42"), eventLog),
+		nil)
+	assert.Ok(t, err)
+
+	assert.Ok(t, createReleaseIn(
+		ctx,
+		app,
+		"file:"+artefactsDir+"/",
+		"deployerspec.zip",
+		nil,
+		"function61/coolproduct",
+		"v1",
+		"9c39d0271d0bd51c7ddfb55dc3051e68b6953c33",
+		log.New(ioutil.Discard, "", 0)))
+
+	assert.Ok(t, app.Reader.LoadUntilRealtime(ctx))
+
+	releases := app.State.All()
+	assert.Assert(t, len(releases) == 1)
+	releaseId := releases[0].Id
+
+	logOutput := &bytes.Buffer{}
+	log.SetOutput(logOutput)
+	defer log.SetOutput(os.Stderr)
+
+	assert.Ok(t, downloadRelease(ctx, "hq", releaseId, "", app, nil))
+
+	downloaded, err := ioutil.ReadFile(filepath.Join(workDir("hq"), "app.tar.gz"))
+	assert.Ok(t, err)
+	assert.EqualString(t, string(downloaded), "app")
+
+	// spec is covered by release's checksum, so only the artefact is warned about
+	assert.Assert(t, strings.Contains(logOutput.String(), "WARN: no checksums for app.tar.gz - will not be verified\n"))
+
+	// spec's checksum recorded in release is still enforced
+	writeZip(t, filepath.Join(artefactsDir, "deployerspec.zip"), map[string]string{
+		"manifest.json": `{"manifest_version_major": 1, "deployer_image": "evil", "download_artefacts": ["app.tar.gz"]}`,
+		"version.json":  `{"friendly_version": "v1"}`,
+	})
+
+	err = downloadRelease(ctx, "other", releaseId, "", app, nil)
+	assert.Assert(t, err != nil)
+	assert.Assert(t, strings.HasPrefix(err.Error(), "checksum mismatch for deployerspec.zip"))
+}
+
+func writeZip(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+
+	file, err := os.Create(path)
+	assert.Ok(t, err)
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+
+	for filename, content := range files {
+		fileWriter, err := zipWriter.Create(filename)
+		assert.Ok(t, err)
+
+		_, err = fileWriter.Write([]byte(content))
+		assert.Ok(t, err)
+	}
+
+	assert.Ok(t, zipWriter.Close())
+}
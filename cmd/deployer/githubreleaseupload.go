@@ -2,26 +2,36 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/function61/deployer/pkg/credentials"
 	"github.com/function61/deployer/pkg/ddomain"
-	"github.com/function61/deployer/pkg/dstate"
 	"github.com/function61/deployer/pkg/githubminiclient"
 	"github.com/function61/eventhorizon/pkg/ehevent"
 	"github.com/function61/gokit/backoff"
 	"github.com/function61/gokit/cryptorandombytes"
-	"github.com/function61/gokit/envvar"
 	"github.com/function61/gokit/retry"
 	"github.com/google/go-github/github"
 	"golang.org/x/oauth2"
 	"golang.org/x/sync/errgroup"
 )
 
+// which steps of release creation to do
+type releaseSteps struct {
+	skipUpload    bool
+	skipChecksums bool // no SHA256SUMS asset, and no checksums in the registered release
+	skipRegister  bool // don't append ReleaseCreated to Event Horizon
+	publish       bool // un-draft after release is registered
+}
+
 func createGithubRelease(
 	ctx context.Context,
 	owner string,
@@ -29,8 +39,14 @@ func createGithubRelease(
 	releaseName string,
 	revisionId string,
 	assetsDir string,
+	deployerSpecFilename string,
+	units map[string]string, // other units than main
+	steps releaseSteps,
 	logger *log.Logger,
 ) error {
+	summary := newReleaseSummary()
+	defer summary.print(logger) // also on errors, so one knows which steps were completed
+
 	repo := githubminiclient.NewRepoRef(owner, repoName)
 
 	ghToken, err := getGitHubToken()
@@ -38,29 +54,27 @@ func createGithubRelease(
 		return err
 	}
 
-	gitHub := github.NewClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
-		AccessToken: ghToken,
-	})))
+	gitHub, err := newGithubClient(ctx, ghToken)
+	if err != nil {
+		return err
+	}
 
-	// search for existing releases, because if artefact uploading fails and we've
+	// search for existing release, because if artefact uploading fails and we've
 	// to re-run this again, we don't want to end up with same release name twice
-	// FIXME: this doesn't take pagination into account
-	existingReleases, _, err := gitHub.Repositories.ListReleases(ctx, repo.Owner, repo.Name, nil)
+	existingRelease, err := findGithubRelease(ctx, gitHub, repo, releaseName)
 	if err != nil {
 		return err
 	}
 
 	var releaseID int64
+	isDraft := true
 
-	for _, existingRelease := range existingReleases {
-		if *existingRelease.Name == releaseName {
-			releaseID = *existingRelease.ID
-			break
-		}
-	}
+	if existingRelease != nil {
+		releaseID = existingRelease.GetID()
+		isDraft = existingRelease.GetDraft()
 
-	// release not found => create one
-	if releaseID == 0 {
+		summary.record("release", "using existing release %d", releaseID)
+	} else { // release not found => create one
 		createdRelease, _, err := gitHub.Repositories.CreateRelease(ctx, repo.Owner, repo.Name, &github.RepositoryRelease{
 			Name:            github.String(releaseName),
 			TagName:         github.String(releaseName),
@@ -72,71 +86,201 @@ func createGithubRelease(
 		}
 
 		releaseID = *createdRelease.ID
+
+		summary.record("release", "created draft release %d", releaseID)
 	}
 
-	if os.Getenv("EVENTHORIZON") == "" { // only notify Event Horizon if we it configured
-		return nil
+	filesToUpload, err := filesInDir(assetsDir)
+	if err != nil {
+		return err
 	}
 
-	app, err := mkApp(ctx)
+	var checksums map[string]ddomain.ArtefactChecksum
+
+	if steps.skipChecksums {
+		summary.record("checksums", "skipped (requested)")
+	} else {
+		checksums, err = checksumsOfDir(assetsDir)
+		if err != nil {
+			return err
+		}
+
+		checksumsDir, err := ioutil.TempDir("", "deployer-checksums-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(checksumsDir)
+
+		sha256SumsPath := filepath.Join(checksumsDir, sha256SumsFilename)
+
+		if err := writeSha256Sums(sha256SumsPath, checksums); err != nil {
+			return err
+		}
+
+		filesToUpload = append(filesToUpload, sha256SumsPath)
+
+		summary.record("checksums", "computed for %d files (%s)", len(checksums), sha256SumsFilename)
+	}
+
+	if steps.skipUpload {
+		summary.record("upload", "skipped (requested)")
+	} else {
+		uploaded, alreadyExisted, err := uploadArtefacts(ctx, filesToUpload, repo, releaseID, gitHub)
+		if err != nil {
+			return err
+		}
+
+		summary.record("upload", "uploaded %d files, %d already existed", uploaded, alreadyExisted)
+	}
+
+	registered, err := func() (bool, error) {
+		switch {
+		case steps.skipRegister:
+			summary.record("register", "skipped (requested)")
+			return false, nil
+		case os.Getenv("EVENTHORIZON") == "": // only notify Event Horizon if we it configured
+			summary.record("register", "skipped (EVENTHORIZON not set)")
+			return false, nil
+		}
+
+		app, err := mkApp(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		if app.State.HasRevisionId(revisionId) { // should not be considered an error
+			summary.record("register", "skipped (already have revision %s)", revisionId)
+			return true, nil
+		}
+
+		releaseCreated := ddomain.NewReleaseCreated(
+			cryptorandombytes.Base64UrlWithoutLeadingDash(4),
+			ownerSlashRepo(repo), // function61/coolproduct
+			releaseName,
+			revisionId,
+			artefactsLocationGithubReleases(repo, releaseID),
+			deployerSpecFilename,
+			units,
+			checksums,
+			ehevent.MetaSystemUser(time.Now())) // TODO: time of commit?
+
+		if err := appendEvents(ctx, app, releaseCreated); err != nil {
+			return false, err
+		}
+
+		summary.record("register", "registered release %s", releaseCreated.Id)
+
+		return true, nil
+	}()
 	if err != nil {
 		return err
 	}
 
-	if app.State.HasRevisionId(revisionId) { // should not be considered an error
-		logger.Printf("WARN: already have revision %s", revisionId)
+	switch {
+	case !steps.publish:
 		return nil
+	case !isDraft:
+		summary.record("publish", "skipped (already published)")
+	case !registered: // only publish registered releases, so a published release is always deployable
+		summary.record("publish", "skipped (release not registered)")
+	default:
+		if _, _, err := gitHub.Repositories.EditRelease(ctx, repo.Owner, repo.Name, releaseID, &github.RepositoryRelease{
+			Draft: github.Bool(false),
+		}); err != nil {
+			return err
+		}
+
+		summary.record("publish", "published")
 	}
 
-	if err := uploadArtefacts(ctx, assetsDir, repo, releaseID, gitHub); err != nil {
-		return err
+	return nil
+}
+
+// outcomes of release creation steps, in order of happening
+type releaseSummary struct {
+	lines []string
+}
+
+func newReleaseSummary() *releaseSummary {
+	return &releaseSummary{lines: []string{}}
+}
+
+func (r *releaseSummary) record(step string, format string, args ...interface{}) {
+	r.lines = append(r.lines, fmt.Sprintf("%-10s %s", step+":", fmt.Sprintf(format, args...)))
+}
+
+func (r *releaseSummary) print(logger *log.Logger) {
+	logger.Println("summary:")
+
+	for _, line := range r.lines {
+		logger.Printf("  %s", line)
 	}
+}
+
+// finds release by name or tag (or nil if not found). looks through all pages since
+// ListReleases() by default only returns the 30 newest.
+// (can't use GetReleaseByTag() since draft releases don't have tags yet)
+func findGithubRelease(
+	ctx context.Context,
+	gitHub *github.Client,
+	repo githubminiclient.RepoRef,
+	releaseName string,
+) (*github.RepositoryRelease, error) {
+	listOptions := &github.ListOptions{PerPage: 100}
+
+	for {
+		releases, res, err := gitHub.Repositories.ListReleases(ctx, repo.Owner, repo.Name, listOptions)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, release := range releases {
+			if release.GetName() == releaseName || release.GetTagName() == releaseName {
+				return release, nil
+			}
+		}
+
+		if res.NextPage == 0 {
+			return nil, nil
+		}
 
-	releaseCreated := ddomain.NewReleaseCreated(
-		cryptorandombytes.Base64UrlWithoutLeadingDash(4),
-		ownerSlashRepo(repo), // function61/coolproduct
-		releaseName,
-		revisionId,
-		artefactsLocationGithubReleases(repo, releaseID),
-		"deployerspec.zip",                 // TODO: this shouldn't be hardcoded
-		ehevent.MetaSystemUser(time.Now())) // TODO: time of commit?
-
-	_, err = app.Writer.Append(
-		ctx,
-		app.TenantCtx.Tenant.Stream(dstate.Stream),
-		[]string{ehevent.Serialize(releaseCreated)})
-	return err
+		listOptions.Page = res.NextPage
+	}
 }
 
+// returns counts of uploaded and already existing (= uploaded by a previous run) files
 func uploadArtefacts(
 	ctx context.Context,
-	assetsDir string,
+	filePaths []string,
 	repo githubminiclient.RepoRef,
 	releaseID int64,
 	gitHub *github.Client,
-) error {
+) (int, int, error) {
+	uploaded := int64(0)
+	alreadyExisted := int64(0)
+
 	startUpload := make(chan string)
 
 	uploaders, uploadersCtx := concurrently(ctx, 3, func(ctx context.Context) error {
 		for filePath := range startUpload {
-			if err := uploadOneArtefact(ctx, filePath, releaseID, gitHub, repo); err != nil {
+			existed, err := uploadOneArtefact(ctx, filePath, releaseID, gitHub, repo)
+			if err != nil {
 				return err
 			}
+
+			if existed {
+				atomic.AddInt64(&alreadyExisted, 1)
+			} else {
+				atomic.AddInt64(&uploaded, 1)
+			}
 		}
 
 		return nil
 	})
 
-	dentries, err := ioutil.ReadDir(assetsDir)
-	if err != nil {
-		return err
-	}
-
 	// func b/c we need return keyword
 	func() {
-		for _, dentry := range dentries {
-			filePath := filepath.Join(assetsDir, dentry.Name())
-
+		for _, filePath := range filePaths {
 			select {
 			case startUpload <- filePath:
 			case <-uploadersCtx.Done():
@@ -152,24 +296,27 @@ func uploadArtefacts(
 
 	// errors if any of the uploads errored
 	if err := uploaders.Wait(); err != nil {
-		return err
+		return 0, 0, err
 	}
 
-	return nil
+	return int(uploaded), int(alreadyExisted), nil
 }
 
+// returns true if the asset already existed
 func uploadOneArtefact(
 	ctx context.Context,
 	filePath string,
 	releaseID int64,
 	gh *github.Client,
 	repo githubminiclient.RepoRef,
-) error {
+) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
 	defer cancel()
 
+	alreadyExisted := false
+
 	// I have observed GitHub asset uploads to regularly fail (even from GitHub runners)
-	return retry.Retry(ctx, func(ctx context.Context) error {
+	err := retry.Retry(ctx, func(ctx context.Context) error {
 		log.Printf("uploading %s", filePath)
 
 		file, err := os.Open(filePath)
@@ -187,6 +334,7 @@ func uploadOneArtefact(
 			// robust against these conditions
 			if res != nil && res.StatusCode == http.StatusUnprocessableEntity {
 				// 422 Validation Failed [{Resource:ReleaseAsset Field:name Code:already_exists Message:}]
+				alreadyExisted = true
 				return nil // was already uploaded so essentially not an error
 			} else {
 				return err
@@ -197,6 +345,8 @@ func uploadOneArtefact(
 	}, backoff.ExponentialWithCappedMax(1*time.Second, 15*time.Second), func(err error) {
 		log.Printf("upload %s try failed: %v", filePath, err)
 	})
+
+	return alreadyExisted, err
 }
 
 func concurrently(
@@ -217,5 +367,42 @@ func concurrently(
 }
 
 func getGitHubToken() (string, error) {
-	return envvar.Required("GITHUB_TOKEN")
+	return credentials.Required(credentialsProvider(), githubTokenName)
+}
+
+func newGithubClient(ctx context.Context, token string) (*github.Client, error) {
+	httpClient := oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
+		AccessToken: token,
+	}))
+
+	apiEndpoint := githubApiEndpoint()
+	if apiEndpoint == githubminiclient.DefaultEndpoint {
+		return github.NewClient(httpClient), nil
+	}
+
+	return github.NewEnterpriseClient(apiEndpoint, githubUploadEndpoint(apiEndpoint), httpClient)
+}
+
+// for GitHub Enterprise: "https://github.example.com/api/v3". same variable name that
+// GitHub Actions uses, so this works out-of-the-box there
+func githubApiEndpoint() string {
+	if endpoint := os.Getenv("GITHUB_API_URL"); endpoint != "" {
+		return strings.TrimSuffix(endpoint, "/")
+	}
+
+	return githubminiclient.DefaultEndpoint
+}
+
+// GitHub Enterprise serves uploads from "/api/uploads" instead of "/api/v3".
+// can be overridden with GITHUB_UPLOAD_URL.
+func githubUploadEndpoint(apiEndpoint string) string {
+	if endpoint := os.Getenv("GITHUB_UPLOAD_URL"); endpoint != "" {
+		return endpoint
+	}
+
+	if strings.HasSuffix(apiEndpoint, "/api/v3") {
+		return strings.TrimSuffix(apiEndpoint, "/api/v3") + "/api/uploads"
+	}
+
+	return apiEndpoint
 }
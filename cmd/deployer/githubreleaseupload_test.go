@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/function61/deployer/pkg/githubminiclient"
+	"github.com/function61/gokit/assert"
+	"github.com/google/go-github/github"
+)
+
+func TestFindGithubReleaseLooksThroughAllPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualString(t, r.URL.Path, "/repos/function61/coolproduct/releases")
+		assert.EqualString(t, r.URL.Query().Get("per_page"), "100")
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s/repos/function61/coolproduct/releases?per_page=100&page=2>; rel="next"`, r.Host))
+			fmt.Fprint(w, `[{"id": 1, "name": "v1.0.1", "tag_name": "v1.0.1"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"id": 2, "name": "v1.0.0", "draft": true}]`)
+		default:
+			t.Fatalf("unexpected page: %s", r.URL.Query().Get("page"))
+		}
+	}))
+	defer server.Close()
+
+	gitHub := github.NewClient(nil)
+	baseUrl, err := url.Parse(server.URL + "/")
+	assert.Ok(t, err)
+	gitHub.BaseURL = baseUrl
+
+	repo := githubminiclient.NewRepoRef("function61", "coolproduct")
+
+	release, err := findGithubRelease(context.TODO(), gitHub, repo, "v1.0.0")
+	assert.Ok(t, err)
+	assert.Assert(t, release.GetID() == 2)
+	assert.Assert(t, release.GetDraft())
+
+	release, err = findGithubRelease(context.TODO(), gitHub, repo, "v2.0.0")
+	assert.Ok(t, err)
+	assert.Assert(t, release == nil)
+}
+
+func TestGithubEnterpriseEndpoints(t *testing.T) {
+	restoreEnv := setEnvs(map[string]string{
+		"GITHUB_API_URL":    "https://github.example.com/api/v3/",
+		"GITHUB_UPLOAD_URL": "",
+	})
+	defer restoreEnv()
+
+	gitHub, err := newGithubClient(context.TODO(), "secret")
+	assert.Ok(t, err)
+
+	assert.EqualString(t, gitHub.BaseURL.String(), "https://github.example.com/api/v3/")
+	assert.EqualString(t, gitHub.UploadURL.String(), "https://github.example.com/api/uploads/")
+
+	assert.EqualString(t, githubUploadEndpoint("http://127.0.0.1:8080"), "http://127.0.0.1:8080")
+}
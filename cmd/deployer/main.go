@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/function61/gokit/dynversion"
 	"github.com/function61/gokit/logex"
@@ -22,45 +23,100 @@ func main() {
 
 	app.AddCommand(releasesEntry(logger))
 
+	app.AddCommand(cacheEntry())
+
 	asInteractive := false
 	keepCache := false
+	plan := false
+	approve := false
+	unit := ""
+	runner := ""
+	tty := ttyFlags{}
+	timeout := time.Duration(0)
 
 	deployCmd := &cobra.Command{
 		Use:   `deploy [serviceId] [releaseId]`,
 		Short: "Directly deploys the service",
 		Args:  cobra.ExactArgs(2),
 		Run: func(_ *cobra.Command, args []string) {
-			exitWithErrorIfErr(deployInternal(
-				ossignal.InterruptOrTerminateBackgroundCtx(logger),
-				args[0],
-				args[1],
-				asInteractive,
-				keepCache,
-			))
+			exitWithErrorIfErr(func() error {
+				allocateTty, err := tty.resolve()
+				if err != nil {
+					return err
+				}
+
+				return deployInternal(
+					ossignal.InterruptOrTerminateBackgroundCtx(logger),
+					args[0],
+					args[1],
+					deployOptions{
+						interactive: asInteractive,
+						keepCache:   keepCache,
+						plan:        plan || approve,
+						approve:     approve,
+						unit:        unit,
+						runner:      runner,
+						tty:         allocateTty,
+						timeout:     timeout,
+					},
+				)
+			}())
 		},
 	}
 	deployCmd.Flags().BoolVarP(&asInteractive, "interactive", "i", asInteractive, "Enters interactive mode (prompt)")
 	deployCmd.Flags().BoolVarP(&keepCache, "keep-cache", "", keepCache, "Do not remove workdir (could be dangerous cross-releases!)")
+	deployCmd.Flags().BoolVarP(&plan, "plan", "", plan, "Only run the plan command (dry-run)")
+	deployCmd.Flags().BoolVarP(&approve, "approve", "", approve, "Run the plan command, then ask for approval to deploy")
+	deployCmd.Flags().StringVarP(&unit, "unit", "", unit, "Deployable unit of the release (default: unit from deployment config, or main unit)")
+	deployCmd.Flags().StringVarP(&runner, "runner", "", runner, runnerFlagUsage)
+	tty.register(deployCmd)
+	deployCmd.Flags().DurationVarP(&timeout, "timeout", "", timeout, "Stop the deployer container if it runs longer than this (default: manifest's timeout, if any)")
 
 	app.AddCommand(deployCmd)
 
-	/*
-		app.AddCommand(&cobra.Command{
-			Use:   "destroy [serviceId]",
-			Short: "Destroys all resources used by service",
-			Args:  cobra.ExactArgs(1),
-			Run: func(_ *cobra.Command, args []string) {
-				panic("not implemented yet")
-			},
-		})
-	*/
+	app.AddCommand(deploymentsEntry(logger))
+
+	app.AddCommand(logsEntry())
 
 	app.AddCommand(&cobra.Command{
+		Use:   "rollback [serviceId]",
+		Short: "Redeploys the previously successfully deployed release",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(rollback(
+				ossignal.InterruptOrTerminateBackgroundCtx(logger),
+				args[0]))
+		},
+	})
+
+	app.AddCommand(destroyEntry(logger))
+
+	app.AddCommand(unlockEntry())
+
+	app.AddCommand(stateEntry(logger))
+
+	artefactsDir := ""
+	signingKeyPath := ""
+
+	packageCmd := &cobra.Command{
 		Use:   "package [friendlyVersion] [outputPackageLocation]",
 		Short: "Packages a spec into a zip",
 		Args:  cobra.ExactArgs(2),
 		Run: func(_ *cobra.Command, args []string) {
-			exitWithErrorIfErr(makePackage(args[0], args[1]))
+			exitWithErrorIfErr(makePackage(args[0], args[1], artefactsDir, signingKeyPath))
+		},
+	}
+	packageCmd.Flags().StringVarP(&artefactsDir, "artefacts-dir", "", artefactsDir, "Record checksums of download_artefacts found in this directory")
+	packageCmd.Flags().StringVarP(&signingKeyPath, "sign-key", "", signingKeyPath, "Sign the package with this private key (writes <outputPackageLocation>.sig)")
+
+	app.AddCommand(packageCmd)
+
+	app.AddCommand(&cobra.Command{
+		Use:   "spec-signing-keygen [privateKeyPath]",
+		Short: "Generates a key pair for signing deployer specs",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(specSigningKeygen(args[0]))
 		},
 	})
 
@@ -133,6 +189,14 @@ func stateDir(serviceId string) string {
 	return deploymentDir(serviceId) + "/state"
 }
 
+func logsDir(serviceId string) string {
+	return deploymentDir(serviceId) + "/logs"
+}
+
+func stateSnapshotsDir(serviceId string) string {
+	return deploymentDir(serviceId) + "/state-snapshots"
+}
+
 func userConfigPath(serviceId string) string {
 	return deploymentDir(serviceId) + "/user-config.json"
 }
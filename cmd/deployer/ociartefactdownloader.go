@@ -1,21 +1,17 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"io"
-	"os/exec"
-	"strings"
 
 	"github.com/function61/deployer/pkg/oci"
-	"github.com/function61/gokit/jsonfile"
 )
 
 type ociArtefactDownloader struct {
-	imageRef           string
-	imageRefWithoutTag string
-	manifest           oci.Manifest
+	ref      oci.Reference
+	manifest oci.Manifest
+	client   *oci.Client
 }
 
 func newOCIArtefactDownloader(ctx context.Context, imageRef string) (artefactDownloader, error) {
@@ -23,29 +19,29 @@ func newOCIArtefactDownloader(ctx context.Context, imageRef string) (artefactDow
 		return nil, fmt.Errorf("newOCIArtefactDownloader: %w", err)
 	}
 
-	orasFetchOutput := &bytes.Buffer{}
-	stderr := &bytes.Buffer{}
-
-	orasFetch := exec.CommandContext(ctx, "oras", "manifest", "fetch", imageRef)
-	orasFetch.Stdout = orasFetchOutput
-	orasFetch.Stderr = stderr
-
-	if err := orasFetch.Run(); err != nil {
-		return withErr(fmt.Errorf("oras manifest fetch %s: %w: stdout[%s] stderr[%s]", imageRef, err, orasFetchOutput.String(), stderr.String()))
+	ref, err := oci.ParseReference(imageRef)
+	if err != nil {
+		return withErr(err)
 	}
 
-	manifest := oci.Manifest{}
-	if err := jsonfile.Unmarshal(orasFetchOutput, &manifest, false); err != nil {
+	client := oci.New(oci.DockerConfigCredentials())
+
+	manifest, _, err := client.ResolveManifest(ctx, *ref)
+	if err != nil {
 		return withErr(err)
 	}
 
-	// "redis:latest" => ["redis", "latest"]
-	imageRefWithoutTag := strings.Split(imageRef, ":")
-	if len(imageRefWithoutTag) != 2 {
-		return withErr(fmt.Errorf("expected imageRef to be <something>:<tag>; got %s", imageRef))
+	return &ociArtefactDownloader{*ref, *manifest, client}, nil
+}
+
+// tags are mutable, so this lets the cache key by content instead
+func (o *ociArtefactDownloader) ArtefactDigest(filename string) (string, bool) {
+	layer, found := o.layerByFilename(filename)
+	if !found {
+		return "", false
 	}
 
-	return &ociArtefactDownloader{imageRef, imageRefWithoutTag[0], manifest}, nil
+	return layer.Digest, true
 }
 
 func (o *ociArtefactDownloader) DownloadArtefact(ctx context.Context, filename string) (io.ReadCloser, error) {
@@ -53,33 +49,25 @@ func (o *ociArtefactDownloader) DownloadArtefact(ctx context.Context, filename s
 		return nil, fmt.Errorf("ociArtefactDownloader.DownloadArtefact: %w", err)
 	}
 
-	layer, found := func() (*oci.Layer, bool) {
-		for _, layer := range o.manifest.Layers {
-			if layer.Annotations["org.opencontainers.image.title"] == filename {
-				return &layer, true
-			}
-		}
-
-		return nil, false
-	}()
+	layer, found := o.layerByFilename(filename)
 	if !found {
-		return withErr(fmt.Errorf("%s not found from manifest of %s", filename, o.imageRef))
+		return withErr(fmt.Errorf("%s not found from manifest of %s", filename, o.ref.String()))
 	}
 
-	// "redis" => "redis@sha256:..."
-	blobRef := o.imageRefWithoutTag + "@" + layer.Digest
-
-	// blobReader, blobWriter := io.Pipe()
-
-	blobReader := &bytes.Buffer{}
+	blob, err := o.client.FetchBlob(ctx, o.ref, *layer)
+	if err != nil {
+		return withErr(err)
+	}
 
-	orasBlobFetch := exec.CommandContext(ctx, "oras", "blob", "fetch", "--output=-", blobRef)
-	orasBlobFetch.Stdout = blobReader
-	// orasBlobFetch.Stdout = blobWriter
+	return blob, nil
+}
 
-	if err := orasBlobFetch.Run(); err != nil {
-		return withErr(err)
+func (o *ociArtefactDownloader) layerByFilename(filename string) (*oci.Layer, bool) {
+	for _, layer := range o.manifest.Layers {
+		if layer.Annotations["org.opencontainers.image.title"] == filename {
+			return &layer, true
+		}
 	}
 
-	return io.NopCloser(blobReader), nil
+	return nil, false
 }
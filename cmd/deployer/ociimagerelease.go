@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/function61/deployer/pkg/ddomain"
-	"github.com/function61/deployer/pkg/dstate"
 	"github.com/function61/deployer/pkg/githubminiclient"
 	"github.com/function61/eventhorizon/pkg/ehevent"
 	"github.com/function61/gokit/cryptorandombytes"
@@ -42,11 +41,9 @@ func createOCIImageRelease(
 		revisionId,
 		"docker://"+imageRef,
 		"",
+		nil,
+		nil,                                // image manifest has digests for all artefacts
 		ehevent.MetaSystemUser(time.Now())) // TODO: time of commit?
 
-	_, err = app.Writer.Append(
-		ctx,
-		app.TenantCtx.Tenant.Stream(dstate.Stream),
-		[]string{ehevent.Serialize(releaseCreated)})
-	return err
+	return appendEvents(ctx, app, releaseCreated)
 }
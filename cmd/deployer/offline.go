@@ -0,0 +1,107 @@
+package main
+
+// Offline mode: deploying from direct artefacts locations doesn't need Event Horizon at
+// all, and releases can be resolved from a local snapshot of the release registry (which
+// is refreshed each time we successfully load it from Event Horizon).
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/function61/deployer/pkg/dstate"
+)
+
+// "true" forces offline mode even if EVENTHORIZON is configured
+const offlineEnvVar = "DEPLOYER_OFFLINE"
+
+func offlineMode() bool {
+	return os.Getenv(offlineEnvVar) == "true"
+}
+
+func eventHorizonConfigured() bool {
+	return os.Getenv("EVENTHORIZON") != "" && !offlineMode()
+}
+
+// returns nil app if releaseId is a direct artefacts location and we don't have Event
+// Horizon configured (= there's nothing to resolve or record)
+func mkAppIfNeeded(ctx context.Context, releaseId string) (*dstate.App, error) {
+	if isDirectArtefactsLocation(releaseId) && !eventHorizonConfigured() {
+		return nil, nil
+	}
+
+	return mkAppOrSnapshot(ctx)
+}
+
+// uses local snapshot only in offline mode. if Event Horizon is unreachable we fail instead
+// of quietly falling back, so deployments don't go unrecorded by accident.
+// use mkApp() instead if you need to record events.
+func mkAppOrSnapshot(ctx context.Context) (*dstate.App, error) {
+	if !offlineMode() {
+		app, err := mkApp(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading from Event Horizon (%s=true uses local snapshot): %w", offlineEnvVar, err)
+		}
+
+		return app, nil
+	}
+
+	snapshotPath, err := registrySnapshotPath()
+	if err != nil {
+		return nil, err
+	}
+
+	app, saved, err := dstate.LoadFromSnapshot(snapshotPath, nil)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf(
+				"offline mode: release registry not available: no local snapshot at %s",
+				snapshotPath)
+		}
+
+		return nil, err
+	}
+
+	log.Printf("offline mode: using release registry snapshot from %s", saved.Local().Format(time.RFC3339))
+
+	return app, nil
+}
+
+// best-effort, because failing to cache shouldn't fail the actual operation
+func saveRegistrySnapshot(app *dstate.App) {
+	if err := func() error {
+		snapshotPath, err := registrySnapshotPath()
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(snapshotPath), 0700); err != nil {
+			return err
+		}
+
+		return app.State.SaveSnapshot(snapshotPath)
+	}(); err != nil {
+		log.Printf("WARN: saving release registry snapshot: %v", err)
+	}
+}
+
+func registrySnapshotPath() (string, error) {
+	if path := os.Getenv("DEPLOYER_REGISTRY_SNAPSHOT"); path != "" {
+		return path, nil
+	}
+
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(userCacheDir, "deployer", "registry-snapshot.json"), nil
+}
+
+// nil app means we're deploying without Event Horizon
+func canRecordEvents(app *dstate.App) bool {
+	return app != nil && !app.Offline()
+}
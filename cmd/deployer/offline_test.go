@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestOfflineDirectArtefactsLocationNeedsNoApp(t *testing.T) {
+	defer setEnvs(map[string]string{"EVENTHORIZON": ""})()
+
+	app, err := mkAppIfNeeded(context.Background(), "file:#deployerspec.zip")
+	assert.Ok(t, err)
+	assert.Assert(t, app == nil)
+
+	deployed := false
+
+	assert.Ok(t, recordDeployment(context.Background(), "hq", "file:#deployerspec.zip", "", "", false, app, func() error {
+		deployed = true
+		return nil
+	}))
+	assert.Assert(t, deployed)
+
+	assert.EqualString(t, appendEvents(context.Background(), app).Error(), "cannot record events in offline mode")
+}
+
+func TestOfflineWithoutSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offline-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	snapshotPath := filepath.Join(dir, "registry-snapshot.json")
+
+	defer setEnvs(map[string]string{
+		"EVENTHORIZON":               "",
+		"DEPLOYER_OFFLINE":           "true",
+		"DEPLOYER_REGISTRY_SNAPSHOT": snapshotPath,
+	})()
+
+	_, err = mkAppIfNeeded(context.Background(), "id1")
+	assert.EqualString(t, err.Error(), "offline mode: release registry not available: no local snapshot at "+snapshotPath)
+}
+
+func TestUnreachableEventHorizonDoesNotFallBackToSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offline-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	snapshotPath := filepath.Join(dir, "registry-snapshot.json")
+
+	defer setEnvs(map[string]string{
+		"EVENTHORIZON":               "",
+		"DEPLOYER_OFFLINE":           "",
+		"DEPLOYER_REGISTRY_SNAPSHOT": snapshotPath,
+	})()
+
+	_, err = mkAppOrSnapshot(context.Background())
+	assert.Assert(t, strings.HasPrefix(err.Error(), "loading from Event Horizon (DEPLOYER_OFFLINE=true uses local snapshot): "))
+}
@@ -2,14 +2,60 @@ package main
 
 import (
 	"archive/zip"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/specsig"
+	"github.com/function61/gokit/atomicfilewrite"
+	"github.com/function61/gokit/fileexists"
 	"github.com/function61/gokit/jsonfile"
 )
 
-func makePackage(friendlyVersion string, outputFile string) error {
+// if artefactsDir given, records checksums of manifest's download_artefacts found from there.
+// if signingKeyPath given, writes a detached signature next to the package.
+func makePackage(
+	friendlyVersion string,
+	outputFile string,
+	artefactsDir string,
+	signingKeyPath string,
+) error {
+	// validate manifest, so we don't accidentally package invalid JSON (fail fast)
+	manifest, err := readAndValidateManifest(".")
+	if err != nil {
+		return err
+	}
+
+	version := &VersionFile{FriendlyVersion: friendlyVersion}
+
+	if artefactsDir != "" {
+		version.Checksums = map[string]ddomain.ArtefactChecksum{}
+
+		for _, artefact := range manifest.DownloadArtefacts {
+			checksum, err := checksumOfFile(filepath.Join(artefactsDir, artefact))
+			if err != nil {
+				return fmt.Errorf("checksum for %s: %w", artefact, err)
+			}
+
+			version.Checksums[artefact] = *checksum
+		}
+	}
+
+	if err := writePackage(outputFile, version); err != nil {
+		return err
+	}
+
+	if signingKeyPath != "" {
+		return signPackage(outputFile, signingKeyPath)
+	}
+
+	return nil
+}
+
+func writePackage(outputFile string, version *VersionFile) error {
 	f, err := os.Create(outputFile)
 	if err != nil {
 		return err
@@ -17,23 +63,17 @@ func makePackage(friendlyVersion string, outputFile string) error {
 	defer f.Close()
 
 	zipWriter := zip.NewWriter(f)
-	defer zipWriter.Close()
 
 	versionFile, err := zipWriter.Create(versionJsonFilename)
 	if err != nil {
 		return err
 	}
 
-	if err := jsonfile.Marshal(versionFile, &VersionFile{FriendlyVersion: friendlyVersion}); err != nil {
-		return nil
-	}
-
-	// validate manifest, so we don't accidentally package invalid JSON (fail fast)
-	if _, err := readAndValidateManifest("."); err != nil {
+	if err := jsonfile.Marshal(versionFile, version); err != nil {
 		return err
 	}
 
-	return filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
+	if err := filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -58,5 +98,59 @@ func makePackage(friendlyVersion string, outputFile string) error {
 		}
 
 		return oFile.Close()
-	})
+	}); err != nil {
+		return err
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func signPackage(packagePath string, signingKeyPath string) error {
+	signingKey, err := ioutil.ReadFile(signingKeyPath)
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(packagePath)
+	if err != nil {
+		return err
+	}
+
+	signature, err := specsig.Sign(content, signingKey)
+	if err != nil {
+		return err
+	}
+
+	return atomicfilewrite.Write(packagePath+specsig.FileSuffix, signature.Write)
+}
+
+func specSigningKeygen(privateKeyPath string) error {
+	exists, err := fileexists.Exists(privateKeyPath)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("%s already exists - it'd be dangerous to overwrite", privateKeyPath)
+	}
+
+	publicKey, privateKey, err := specsig.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(privateKeyPath, privateKey, 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf(
+		"Wrote private key to %s\nAdd public key to trusted_spec_keys of deployments that should trust it:\n\t%s\n",
+		privateKeyPath,
+		publicKey)
+
+	return nil
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,8 +11,10 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/function61/deployer/pkg/ddomain"
 	"github.com/function61/deployer/pkg/dstate"
 	"github.com/function61/deployer/pkg/githubminiclient"
+	"github.com/function61/deployer/pkg/specsig"
 	"github.com/function61/eventhorizon/pkg/ehreader"
 	"github.com/function61/gokit/atomicfilewrite"
 	"github.com/function61/gokit/fileexists"
@@ -41,7 +44,7 @@ func listReleasesEntrypoint(logger *log.Logger) *cobra.Command {
 }
 
 func listReleases(ctx context.Context, allowTruncate bool) error {
-	app, err := mkApp(ctx)
+	app, err := mkAppOrSnapshot(ctx)
 	if err != nil {
 		return err
 	}
@@ -79,48 +82,89 @@ func listReleases(ctx context.Context, allowTruncate bool) error {
 	return nil
 }
 
-func resolveReleaseArtefactsLocationAndDeployerSpecFilename(releaseId string, app *dstate.App) (string, string, error) {
-	release, err := app.State.ById(releaseId)
-	if err != nil {
-		return "", "", err
+// returns nil if releaseId is a direct artefacts location
+func resolveRelease(releaseId string, app *dstate.App) (*dstate.SoftwareRelease, error) {
+	if isDirectArtefactsLocation(releaseId) {
+		return nil, nil
 	}
 
-	deployerSpecFilename := release.DeployerSpecFilename
-	if deployerSpecFilename == "" {
-		deployerSpecFilename = "deployerspec.zip"
-	}
+	return app.State.ById(releaseId)
+}
 
-	return release.ArtefactsLocation, deployerSpecFilename, nil
+// "https://example.com/dl/#deployerspec.zip" instead of release ID
+func isDirectArtefactsLocation(releaseId string) bool {
+	return strings.Contains(releaseId, ":")
 }
 
-func downloadRelease(ctx context.Context, serviceId string, releaseId string, app *dstate.App) error {
-	if strings.Contains(releaseId, ":") {
+type releaseArtefacts struct {
+	location             string
+	deployerSpecFilename string
+	checksums            map[string]ddomain.ArtefactChecksum // empty if release has no checksums
+}
+
+// unit "" means the main deployment unit
+func resolveReleaseArtefacts(releaseId string, unit string, app *dstate.App) (*releaseArtefacts, error) {
+	if isDirectArtefactsLocation(releaseId) {
+		if unit != "" {
+			return nil, fmt.Errorf("unit '%s' given but direct artefacts location already names the deployer spec", unit)
+		}
+
 		// expecting file:#deployerspec.zip
 		// expecting http://example.com/files/#deployerspec.zip
 		parts := strings.Split(releaseId, "#")
 		if len(parts) != 2 {
-			return fmt.Errorf("don't know how to do hash-less parsing yet: %s", releaseId)
+			return nil, fmt.Errorf("don't know how to do hash-less parsing yet: %s", releaseId)
 		}
 
-		return downloadReleaseWith(ctx, serviceId, parts[0], parts[1])
-	} else {
-		artefactsLocation, deployerSpecFilename, err := resolveReleaseArtefactsLocationAndDeployerSpecFilename(
-			releaseId,
-			app)
-		if err != nil {
-			return fmt.Errorf("resolveReleaseArtefactsLocationAndDeployerSpecFilename: %w", err)
-		}
+		return &releaseArtefacts{
+			location:             parts[0],
+			deployerSpecFilename: parts[1],
+		}, nil
+	}
+
+	release, err := app.State.ById(releaseId)
+	if err != nil {
+		return nil, err
+	}
+
+	deployerSpecFilename, err := release.DeployerSpecFilenameForUnit(unit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &releaseArtefacts{
+		location:             release.ArtefactsLocation,
+		deployerSpecFilename: deployerSpecFilename,
+		checksums:            release.Checksums,
+	}, nil
+}
 
-		return downloadReleaseWith(ctx, serviceId, artefactsLocation, deployerSpecFilename)
+// trustedKeys optional. see extractSpecFromReader()
+func downloadRelease(
+	ctx context.Context,
+	serviceId string,
+	releaseId string,
+	unit string,
+	app *dstate.App,
+	trustedKeys []string,
+) error {
+	release, err := resolveReleaseArtefacts(releaseId, unit, app)
+	if err != nil {
+		return fmt.Errorf("resolveReleaseArtefacts: %w", err)
 	}
+
+	return downloadReleaseWith(ctx, serviceId, *release, trustedKeys)
 }
 
 func downloadReleaseWith(
 	ctx context.Context,
 	serviceId string,
-	artefactsLocation string,
-	deployerSpecFilename string,
+	release releaseArtefacts,
+	trustedKeys []string,
 ) error {
+	artefactsLocation := release.location
+	deployerSpecFilename := release.deployerSpecFilename
+
 	// each unique release has different artefactsLocation, so instead of using releaseId
 	// hash the location to remove dependency to release ID (so we can deploy manually
 	// for testing/dev purposes)
@@ -139,20 +183,19 @@ func downloadReleaseWith(
 		return nil // nothing to do here :)
 	}
 
-	// var gmc *githubminiclient.Client
-	ghToken, err := getGitHubToken()
-	if err != nil {
-		return err
-	}
+	log.Printf("artefacts source: %s", artefactsLocation)
 
-	gmc, err := githubminiclient.New(githubminiclient.AccessToken(ghToken))
+	artefactsUncached, err := makeArtefactDownloader(ctx, artefactsLocation, credentialsProvider())
 	if err != nil {
 		return err
 	}
 
-	log.Printf("artefacts source: %s", artefactsLocation)
+	progress := newDownloadProgress(os.Stderr)
 
-	artefacts, err := makeArtefactDownloader(ctx, artefactsLocation, gmc)
+	artefacts, err := withArtefactCache(artefactsLocation, &progressArtefactDownloader{
+		inner:    artefactsUncached,
+		progress: progress,
+	})
 	if err != nil {
 		return err
 	}
@@ -169,13 +212,69 @@ func downloadReleaseWith(
 	}
 	defer deployerSpecReader.Close()
 
+	deployerSpecVerified, err := verifyChecksumIfKnown(deployerSpecReader, deployerSpecFilename, release.checksums)
+	if err != nil {
+		return err
+	}
+
+	var deployerSpecSignature *specsig.Signature
+	if len(trustedKeys) > 0 {
+		deployerSpecSignature, err = downloadSpecSignature(ctx, artefacts, deployerSpecFilename)
+		if err != nil {
+			return err
+		}
+	}
+
 	log.Printf("extracting %s", deployerSpecFilename)
 
-	if err := extractSpecFromReader(serviceId, deployerSpecReader); err != nil {
+	if err := extractSpecFromReader(
+		serviceId,
+		deployerSpecVerified,
+		deployerSpecSignature,
+		trustedKeys,
+	); err != nil {
+		return evictIfChecksumMismatch(artefacts, deployerSpecFilename, err)
+	}
+
+	vam, err := loadVersionAndManifest(serviceId)
+	if err != nil {
 		return err
 	}
 
-	downloadOneArtefact := func(filename string) error { // for defers
+	// release-level checksums take precedence, since they're recorded outside of the spec
+	checksums := map[string]ddomain.ArtefactChecksum{}
+	for filename, checksum := range vam.Version.Checksums {
+		checksums[filename] = checksum
+	}
+	for filename, checksum := range release.checksums {
+		checksums[filename] = checksum
+	}
+
+	// release-level checksums cover every asset of releases created from a local dir, but
+	// "releases mk" registers remote artefacts without downloading them, so it only has
+	// the specs'. the spec's own checksums promise to cover every artefact, so with them
+	// a missing checksum is an error instead of a warning.
+	allArtefactsChecksummed := len(vam.Version.Checksums) > 0
+
+	// (OCI client verifies digests by itself)
+	if !allArtefactsChecksummed && !strings.HasPrefix(artefactsLocation, "docker://") {
+		unverified := []string{}
+		for _, filename := range vam.Manifest.DownloadArtefacts {
+			if _, known := checksums[filename]; !known {
+				unverified = append(unverified, filename)
+			}
+		}
+
+		if len(unverified) > 0 {
+			log.Printf("WARN: no checksums for %s - will not be verified", strings.Join(unverified, ", "))
+		}
+	}
+
+	for filename, checksum := range checksums {
+		progress.SetExpectedSize(filename, checksum.Size)
+	}
+
+	downloadOneArtefact := func(ctx context.Context, filename string) error { // for defers
 		withErr := func(err error) error { return fmt.Errorf("downloadOneArtefact: %s: %w", filename, err) }
 
 		localFilename := filepath.Join(workDir(serviceId), filename)
@@ -186,7 +285,7 @@ func downloadReleaseWith(
 		}
 
 		if exists {
-			log.Println("  already downloaded") // we already have log context from previous line
+			log.Printf("%s already downloaded", filename)
 			return nil
 		}
 
@@ -196,30 +295,116 @@ func downloadReleaseWith(
 		}
 		defer artefactContent.Close()
 
+		artefactVerified := io.Reader(artefactContent)
+		if _, known := checksums[filename]; known || allArtefactsChecksummed {
+			artefactVerified, err = verifyChecksumIfKnown(artefactContent, filename, checksums)
+			if err != nil {
+				return withErr(err)
+			}
+		}
+
 		if err := atomicfilewrite.Write(localFilename, func(dest io.Writer) error {
-			_, err := io.Copy(dest, artefactContent)
+			_, err := io.Copy(dest, artefactVerified)
 			return err
 		}); err != nil {
-			return withErr(err)
+			return withErr(evictIfChecksumMismatch(artefacts, filename, err))
 		}
 
 		return nil
 	}
 
-	vam, err := loadVersionAndManifest(serviceId)
+	renderCtx, stopRendering := context.WithCancel(ctx)
+	renderDone := make(chan interface{})
+	go func() {
+		defer close(renderDone)
+		progress.Render(renderCtx)
+	}()
+
+	err = downloadArtefactsConcurrently(ctx, vam.Manifest.DownloadArtefacts, func(ctx context.Context, filename string) error {
+		logDownload(filename)
+
+		return downloadOneArtefact(ctx, filename)
+	})
+
+	stopRendering()
+	<-renderDone
+
 	if err != nil {
+		return err // all-downloaded flag is not written, so the next run retries the rest
+	}
+
+	return touch(allDownloadedFlagPath)
+}
+
+// otherwise a corrupted (or tampered) cached artefact would fail every deploy until the
+// cache is pruned by hand. returns err as-is.
+func evictIfChecksumMismatch(artefacts artefactDownloader, filename string, err error) error {
+	mismatch := &checksumMismatchError{}
+	if !errors.As(err, &mismatch) {
 		return err
 	}
 
-	for _, downloadArtefact := range vam.Manifest.DownloadArtefacts {
-		logDownload(downloadArtefact)
+	if evicter, ok := artefacts.(artefactEvicter); ok {
+		if errEvict := evicter.EvictArtefact(filename); errEvict != nil {
+			return fmt.Errorf("%w (also failed evicting from cache: %v)", err, errEvict)
+		}
 
-		if err := downloadOneArtefact(downloadArtefact); err != nil {
-			return err
+		log.Printf("evicted %s from cache", filename)
+	}
+
+	return err
+}
+
+func downloadArtefactsConcurrently(
+	ctx context.Context,
+	filenames []string,
+	downloadOne func(ctx context.Context, filename string) error,
+) error {
+	startDownload := make(chan string)
+
+	downloaders, downloadersCtx := concurrently(ctx, 3, func(ctx context.Context) error {
+		for filename := range startDownload {
+			if err := downloadOne(ctx, filename); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	// func b/c we need return keyword
+	func() {
+		for _, filename := range filenames {
+			select {
+			case startDownload <- filename:
+			case <-downloadersCtx.Done():
+				// downloaders aborted - stop submitting work. Wait() will return the error
+				return
+			}
 		}
+	}()
+
+	close(startDownload)
+
+	return downloaders.Wait()
+}
+
+// returns nil signature if spec is not signed
+func downloadSpecSignature(
+	ctx context.Context,
+	artefacts artefactDownloader,
+	deployerSpecFilename string,
+) (*specsig.Signature, error) {
+	signatureReader, err := artefacts.DownloadArtefact(ctx, deployerSpecFilename+specsig.FileSuffix)
+	if err != nil {
+		// downloaders don't have a uniform "not found" error, so treat as unsigned. if
+		// signature is required this is an error anyway, with a clear message.
+		log.Printf("no signature for %s: %v", deployerSpecFilename, err)
+		return nil, nil
 	}
+	defer signatureReader.Close()
 
-	return touch(allDownloadedFlagPath)
+	return specsig.Read(signatureReader)
 }
 
 func releasesEntry(logger *log.Logger) *cobra.Command {
@@ -230,6 +415,8 @@ func releasesEntry(logger *log.Logger) *cobra.Command {
 
 	cmd.AddCommand(listReleasesEntrypoint(logger))
 
+	cmd.AddCommand(createReleaseEntry(logger))
+
 	cmd.AddCommand(&cobra.Command{
 		Use:   "oci-image-release-mk [imageRef] [owner] [repo] [releaseName] [revisionId]",
 		Short: "Create (container) image release",
@@ -247,22 +434,83 @@ func releasesEntry(logger *log.Logger) *cobra.Command {
 		},
 	})
 
-	cmd.AddCommand(&cobra.Command{
-		Use:   "githubrelease-mk [owner] [repo] [releaseName] [revisionId] [assetDir]",
-		Short: "Create GitHub release",
-		Args:  cobra.ExactArgs(5),
-		Run: func(_ *cobra.Command, args []string) {
-			exitWithErrorIfErr(createGithubRelease(
-				ossignal.InterruptOrTerminateBackgroundCtx(logger),
-				args[0],
-				args[1],
-				args[2],
-				args[3],
-				args[4],
-				logger,
-			))
-		},
-	})
+	cmd.AddCommand(func() *cobra.Command {
+		units := releaseUnitFlags{}
+		steps := releaseSteps{}
+
+		cmd := &cobra.Command{
+			Use:   "githubrelease-mk [owner] [repo] [releaseName] [revisionId] [assetDir]",
+			Short: "Create GitHub release",
+			Args:  cobra.ExactArgs(5),
+			Run: func(_ *cobra.Command, args []string) {
+				exitWithErrorIfErr(func() error {
+					deployerSpecFilename, otherUnits, err := units.parse()
+					if err != nil {
+						return err
+					}
+
+					return createGithubRelease(
+						ossignal.InterruptOrTerminateBackgroundCtx(logger),
+						args[0],
+						args[1],
+						args[2],
+						args[3],
+						args[4],
+						deployerSpecFilename,
+						otherUnits,
+						steps,
+						logger,
+					)
+				}())
+			},
+		}
+
+		units.register(cmd)
+		cmd.Flags().BoolVarP(&steps.skipUpload, "skip-upload", "", steps.skipUpload, "Don't upload assets")
+		cmd.Flags().BoolVarP(&steps.skipChecksums, "skip-checksums", "", steps.skipChecksums, "Don't compute checksums (nor upload "+sha256SumsFilename+")")
+		cmd.Flags().BoolVarP(&steps.skipRegister, "skip-register", "", steps.skipRegister, "Don't register the release to Event Horizon")
+		cmd.Flags().BoolVarP(&steps.publish, "publish", "", steps.publish, "Publish the (draft) release once it's registered")
+
+		return cmd
+	}())
+
+	cmd.AddCommand(func() *cobra.Command {
+		units := releaseUnitFlags{}
+
+		cmd := &cobra.Command{
+			Use:   "s3release-mk [s3Location] [owner] [repo] [releaseName] [revisionId] [assetDir]",
+			Short: "Create release by uploading artefacts to S3 (s3://bucket/prefix/)",
+			Args:  cobra.ExactArgs(6),
+			Run: func(_ *cobra.Command, args []string) {
+				exitWithErrorIfErr(func() error {
+					deployerSpecFilename, otherUnits, err := units.parse()
+					if err != nil {
+						return err
+					}
+
+					return createS3Release(
+						ossignal.InterruptOrTerminateBackgroundCtx(logger),
+						args[0],
+						args[1],
+						args[2],
+						args[3],
+						args[4],
+						args[5],
+						deployerSpecFilename,
+						otherUnits,
+						logger,
+					)
+				}())
+			},
+		}
+
+		units.register(cmd)
+
+		return cmd
+	}())
+
+	cmd.AddCommand(selfHostedReleaseMkEntry(giteaReleaseScheme, "Gitea", logger))
+	cmd.AddCommand(selfHostedReleaseMkEntry(gitlabReleaseScheme, "GitLab", logger))
 
 	cmd.AddCommand(&cobra.Command{
 		Use:   "dl [serviceId] [releaseId]",
@@ -272,7 +520,7 @@ func releasesEntry(logger *log.Logger) *cobra.Command {
 			exitWithErrorIfErr(func() error {
 				ctx := ossignal.InterruptOrTerminateBackgroundCtx(logger)
 
-				app, err := mkApp(ctx)
+				app, err := mkAppIfNeeded(ctx, args[1])
 				if err != nil {
 					return err
 				}
@@ -281,7 +529,9 @@ func releasesEntry(logger *log.Logger) *cobra.Command {
 					ctx,
 					args[0],
 					args[1],
+					"",
 					app,
+					nil,
 				)
 			}())
 		},
@@ -290,6 +540,47 @@ func releasesEntry(logger *log.Logger) *cobra.Command {
 	return cmd
 }
 
+// deployable units of a release. main unit's spec is --spec-filename, others via
+// repeatable --unit=name=filename
+type releaseUnitFlags struct {
+	deployerSpecFilename string
+	units                []string
+}
+
+func (r *releaseUnitFlags) register(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&r.deployerSpecFilename, "spec-filename", "", "deployerspec.zip", "Filename of main unit's deployer spec")
+	cmd.Flags().StringArrayVarP(&r.units, "unit", "", []string{}, "Additional deployable unit (name=specFilename), can be repeated")
+}
+
+func (r *releaseUnitFlags) parse() (string, map[string]string, error) {
+	units, err := parseUnits(r.units)
+	return r.deployerSpecFilename, units, err
+}
+
+// ["worker=deployerspec-worker.zip"] => {"worker": "deployerspec-worker.zip"}. nil if no units.
+func parseUnits(unitSpecs []string) (map[string]string, error) {
+	if len(unitSpecs) == 0 {
+		return nil, nil
+	}
+
+	units := map[string]string{}
+
+	for _, unitSpec := range unitSpecs {
+		parts := strings.SplitN(unitSpec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid unit '%s'; expecting name=specFilename", unitSpec)
+		}
+
+		if _, duplicate := units[parts[0]]; duplicate {
+			return nil, fmt.Errorf("duplicate unit: %s", parts[0])
+		}
+
+		units[parts[0]] = parts[1]
+	}
+
+	return units, nil
+}
+
 func ownerSlashRepo(repo githubminiclient.RepoRef) string {
 	return fmt.Sprintf("%s/%s", repo.Owner, repo.Name)
 }
@@ -310,7 +601,12 @@ func touch(filename string) error {
 	return file.Close()
 }
 
+// for operations that record events. see mkAppOrSnapshot() for read-only use.
 func mkApp(ctx context.Context) (*dstate.App, error) {
+	if offlineMode() {
+		return nil, fmt.Errorf("%s=true but this operation needs Event Horizon", offlineEnvVar)
+	}
+
 	tenantCtx, err := ehreader.TenantCtxFrom(ehreader.ConfigFromEnv)
 	if err != nil {
 		return nil, err
@@ -324,5 +620,7 @@ func mkApp(ctx context.Context) (*dstate.App, error) {
 		return nil, err
 	}
 
+	saveRegistrySnapshot(app)
+
 	return app, nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestParseUnits(t *testing.T) {
+	units, err := parseUnits([]string{"worker=deployerspec-worker.zip", "api=deployerspec-api.zip"})
+	assert.Ok(t, err)
+
+	assert.Assert(t, len(units) == 2)
+	assert.EqualString(t, units["worker"], "deployerspec-worker.zip")
+	assert.EqualString(t, units["api"], "deployerspec-api.zip")
+
+	units, err = parseUnits(nil)
+	assert.Ok(t, err)
+	assert.Assert(t, units == nil)
+
+	_, err = parseUnits([]string{"worker"})
+	assert.EqualString(t, err.Error(), "invalid unit 'worker'; expecting name=specFilename")
+
+	_, err = parseUnits([]string{"worker=a.zip", "worker=b.zip"})
+	assert.EqualString(t, err.Error(), "duplicate unit: worker")
+}
+
+func TestResolveReleaseArtefactsDirectLocation(t *testing.T) {
+	release, err := resolveReleaseArtefacts("https://example.com/dl/#deployerspec.zip", "", nil)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, release.location, "https://example.com/dl/")
+	assert.EqualString(t, release.deployerSpecFilename, "deployerspec.zip")
+
+	_, err = resolveReleaseArtefacts("https://example.com/dl/#deployerspec.zip", "worker", nil)
+	assert.EqualString(t, err.Error(), "unit 'worker' given but direct artefacts location already names the deployer spec")
+}
+
+func TestDownloadArtefactsConcurrently(t *testing.T) {
+	downloaded := []string{}
+	mu := sync.Mutex{}
+
+	assert.Ok(t, downloadArtefactsConcurrently(
+		context.Background(),
+		[]string{"a.zip", "b.zip", "c.zip", "d.zip", "e.zip"},
+		func(_ context.Context, filename string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			downloaded = append(downloaded, filename)
+			return nil
+		}))
+
+	sort.Strings(downloaded)
+	assert.EqualString(t, strings.Join(downloaded, ","), "a.zip,b.zip,c.zip,d.zip,e.zip")
+}
+
+func TestDownloadArtefactsConcurrentlyCancelsSiblingsOnError(t *testing.T) {
+	started := []string{}
+	canceled := []string{}
+	mu := sync.Mutex{}
+
+	err := downloadArtefactsConcurrently(
+		context.Background(),
+		[]string{"slow1.zip", "slow2.zip", "broken.zip", "never1.zip", "never2.zip"},
+		func(ctx context.Context, filename string) error {
+			mu.Lock()
+			started = append(started, filename)
+			mu.Unlock()
+
+			if filename == "broken.zip" {
+				return errors.New("broken.zip: connection reset")
+			}
+
+			select {
+			case <-ctx.Done():
+				mu.Lock()
+				canceled = append(canceled, filename)
+				mu.Unlock()
+				return ctx.Err()
+			case <-time.After(10 * time.Second):
+				return errors.New("sibling download was not canceled")
+			}
+		})
+	assert.EqualString(t, err.Error(), "broken.zip: connection reset")
+
+	sort.Strings(started)
+	sort.Strings(canceled)
+
+	// all workers were busy when broken.zip failed, so the rest were never started
+	assert.EqualString(t, strings.Join(started, ","), "broken.zip,slow1.zip,slow2.zip")
+	assert.EqualString(t, strings.Join(canceled, ","), "slow1.zip,slow2.zip")
+}
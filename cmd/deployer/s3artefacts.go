@@ -0,0 +1,225 @@
+package main
+
+// S3 (or S3-compatible) artefact location: "s3://bucket/prefix/"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/githubminiclient"
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/cryptorandombytes"
+)
+
+const s3Scheme = "s3://"
+
+// for S3-compatible stores like MinIO. implies path-style addressing.
+const s3EndpointEnvVar = "DEPLOYER_S3_ENDPOINT"
+
+type s3Location struct {
+	bucket string
+	prefix string // "" or ends in "/"
+}
+
+func parseS3Location(uri string) (*s3Location, error) {
+	if !strings.HasPrefix(uri, s3Scheme) {
+		return nil, fmt.Errorf("expecting %s; got '%s'", s3Scheme, uri)
+	}
+
+	bucketAndPrefix := strings.SplitN(uri[len(s3Scheme):], "/", 2)
+	if bucketAndPrefix[0] == "" {
+		return nil, fmt.Errorf("bucket missing from S3 location: %s", uri)
+	}
+
+	prefix := ""
+	if len(bucketAndPrefix) == 2 {
+		prefix = bucketAndPrefix[1]
+	}
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &s3Location{
+		bucket: bucketAndPrefix[0],
+		prefix: prefix,
+	}, nil
+}
+
+func (s s3Location) String() string {
+	return s3Scheme + s.bucket + "/" + s.prefix
+}
+
+func (s s3Location) key(filename string) string {
+	return s.prefix + filename
+}
+
+// credentials and region from the usual AWS env vars / shared config (~/.aws), with
+// support for custom endpoint (DEPLOYER_S3_ENDPOINT)
+func newS3Client() (*s3.S3, error) {
+	conf := aws.NewConfig()
+
+	if endpoint := os.Getenv(s3EndpointEnvVar); endpoint != "" {
+		conf = conf.WithEndpoint(endpoint).WithS3ForcePathStyle(true)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *conf,
+		SharedConfigState: session.SharedConfigEnable, // makes AWS_PROFILE & region from ~/.aws/config work
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.StringValue(sess.Config.Region) == "" {
+		// SDK requires a region for signing. S3-compatible stores usually don't care.
+		sess.Config.Region = aws.String("us-east-1")
+	}
+
+	return s3.New(sess), nil
+}
+
+type s3ArtefactDownloader struct {
+	location s3Location
+	s3       *s3.S3
+}
+
+func newS3ArtefactDownloader(uri string) (*s3ArtefactDownloader, error) {
+	location, err := parseS3Location(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newS3Client()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3ArtefactDownloader{*location, client}, nil
+}
+
+func (s *s3ArtefactDownloader) DownloadArtefact(
+	ctx context.Context,
+	filename string,
+) (io.ReadCloser, error) {
+	res, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.location.bucket),
+		Key:    aws.String(s.location.key(filename)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3 GetObject %s: %w", s.location.key(filename), err)
+	}
+
+	return res.Body, nil
+}
+
+func createS3Release(
+	ctx context.Context,
+	location string,
+	owner string,
+	repoName string,
+	releaseName string,
+	revisionId string,
+	assetsDir string,
+	deployerSpecFilename string,
+	units map[string]string, // other units than main
+	logger *log.Logger,
+) error {
+	s3Loc, err := parseS3Location(location)
+	if err != nil {
+		return err
+	}
+
+	repo := githubminiclient.NewRepoRef(owner, repoName)
+
+	app, err := mkApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if app.State.HasRevisionId(revisionId) { // should not be considered an error
+		logger.Printf("WARN: already have revision %s", revisionId)
+		return nil
+	}
+
+	client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	if err := uploadArtefactsToS3(ctx, assetsDir, *s3Loc, client); err != nil {
+		return err
+	}
+
+	checksums, err := checksumsOfDir(assetsDir)
+	if err != nil {
+		return err
+	}
+
+	releaseCreated := ddomain.NewReleaseCreated(
+		cryptorandombytes.Base64UrlWithoutLeadingDash(4),
+		ownerSlashRepo(repo), // function61/coolproduct
+		releaseName,
+		revisionId,
+		s3Loc.String(),
+		deployerSpecFilename,
+		units,
+		checksums,
+		ehevent.MetaSystemUser(time.Now()))
+
+	return appendEvents(ctx, app, releaseCreated)
+}
+
+func uploadArtefactsToS3(
+	ctx context.Context,
+	assetsDir string,
+	location s3Location,
+	client *s3.S3,
+) error {
+	dentries, err := ioutil.ReadDir(assetsDir)
+	if err != nil {
+		return err
+	}
+
+	// does multipart uploads for large files
+	uploader := s3manager.NewUploaderWithClient(client)
+
+	for _, dentry := range dentries {
+		if dentry.IsDir() {
+			continue
+		}
+
+		if err := func() error {
+			log.Printf("uploading %s", dentry.Name())
+
+			file, err := os.Open(filepath.Join(assetsDir, dentry.Name()))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			_, err = uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+				Bucket: aws.String(location.bucket),
+				Key:    aws.String(location.key(dentry.Name())),
+				Body:   file,
+			})
+			return err
+		}(); err != nil {
+			return fmt.Errorf("upload %s: %w", dentry.Name(), err)
+		}
+	}
+
+	return nil
+}
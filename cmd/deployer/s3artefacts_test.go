@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestParseS3Location(t *testing.T) {
+	location, err := parseS3Location("s3://mybucket/releases/coolproduct")
+	assert.Ok(t, err)
+
+	assert.EqualString(t, location.bucket, "mybucket")
+	assert.EqualString(t, location.key("app.tar.gz"), "releases/coolproduct/app.tar.gz")
+	assert.EqualString(t, location.String(), "s3://mybucket/releases/coolproduct/")
+
+	location, err = parseS3Location("s3://mybucket")
+	assert.Ok(t, err)
+
+	assert.EqualString(t, location.key("app.tar.gz"), "app.tar.gz")
+
+	_, err = parseS3Location("s3:///prefix/")
+	assert.EqualString(t, err.Error(), "bucket missing from S3 location: s3:///prefix/")
+}
+
+func TestS3UploadAndDownload(t *testing.T) {
+	store := newFakeS3()
+
+	server := httptest.NewServer(store)
+	defer server.Close()
+
+	restoreEnv := setEnvs(map[string]string{
+		s3EndpointEnvVar:        server.URL,
+		"AWS_ACCESS_KEY_ID":     "AKIDEXAMPLE",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_REGION":            "eu-central-1",
+	})
+	defer restoreEnv()
+
+	assetsDir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(assetsDir)
+
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(assetsDir, "deployerspec.zip"), []byte("spec"), 0644))
+
+	location, err := parseS3Location("s3://mybucket/coolproduct/v1.2.3/")
+	assert.Ok(t, err)
+
+	client, err := newS3Client()
+	assert.Ok(t, err)
+
+	assert.Ok(t, uploadArtefactsToS3(context.TODO(), assetsDir, *location, client))
+
+	assert.EqualString(t, store.objects["/mybucket/coolproduct/v1.2.3/deployerspec.zip"], "spec")
+
+	downloader, err := makeArtefactDownloader(context.TODO(), "s3://mybucket/coolproduct/v1.2.3/", nil)
+	assert.Ok(t, err)
+
+	content, err := downloader.DownloadArtefact(context.TODO(), "deployerspec.zip")
+	assert.Ok(t, err)
+	defer content.Close()
+
+	contentBytes, err := ioutil.ReadAll(content)
+	assert.Ok(t, err)
+
+	assert.EqualString(t, string(contentBytes), "spec")
+
+	_, err = downloader.DownloadArtefact(context.TODO(), "notfound.zip")
+	assert.Assert(t, err != nil)
+	assert.Assert(t, strings.Contains(err.Error(), "NoSuchKey"))
+}
+
+// bare minimum of S3's path-style API for GetObject and PutObject
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]string // "/bucket/key" => content
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string]string{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		http.Error(w, "not SigV4 signed", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		content, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.objects[r.URL.Path] = string(content)
+	case http.MethodGet:
+		content, found := f.objects[r.URL.Path]
+		if !found {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+			return
+		}
+
+		_, _ = w.Write([]byte(content))
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
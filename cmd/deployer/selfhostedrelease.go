@@ -0,0 +1,302 @@
+package main
+
+// Releases in (usually self-hosted) Gitea and GitLab:
+//   "gitearelease:git.example.com/owner/repo/<release ID>"
+//   "gitlabrelease:gitlab.com/group/subgroup/project/<tag name>"
+// hosts default to https://, but "gitearelease:http://localhost:3000/owner/repo/1" works too.
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/function61/deployer/pkg/credentials"
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/releasehost"
+	"github.com/function61/eventhorizon/pkg/ehevent"
+	"github.com/function61/gokit/cryptorandombytes"
+	"github.com/function61/gokit/ossignal"
+	"github.com/spf13/cobra"
+)
+
+const (
+	giteaReleaseScheme  = "gitearelease:"
+	gitlabReleaseScheme = "gitlabrelease:"
+)
+
+type selfHostedReleaseLocation struct {
+	scheme    string // giteaReleaseScheme | gitlabReleaseScheme
+	baseUrl   string // "https://git.example.com"
+	repo      releasehost.RepoRef
+	releaseId string
+}
+
+func parseSelfHostedReleaseLocation(uri string) (*selfHostedReleaseLocation, error) {
+	scheme := ""
+	for _, candidate := range []string{giteaReleaseScheme, gitlabReleaseScheme} {
+		if strings.HasPrefix(uri, candidate) {
+			scheme = candidate
+		}
+	}
+	if scheme == "" {
+		return nil, fmt.Errorf("expecting %s or %s; got '%s'", giteaReleaseScheme, gitlabReleaseScheme, uri)
+	}
+
+	withoutScheme := uri[len(scheme):]
+
+	urlScheme := "https://"
+	for _, candidate := range []string{"http://", "https://"} {
+		if strings.HasPrefix(withoutScheme, candidate) {
+			urlScheme = candidate
+			withoutScheme = withoutScheme[len(candidate):]
+		}
+	}
+
+	// host, owner (1 or more components), repo, release ID
+	components := strings.Split(withoutScheme, "/")
+	if len(components) < 4 {
+		return nil, fmt.Errorf(
+			"invalid syntax for %s; expecting host/owner/repo/releaseId, got %s",
+			scheme,
+			withoutScheme)
+	}
+
+	last := len(components) - 1
+
+	return &selfHostedReleaseLocation{
+		scheme:  scheme,
+		baseUrl: urlScheme + components[0],
+		repo: releasehost.NewRepoRef(
+			strings.Join(components[1:last-1], "/"),
+			components[last-1]),
+		releaseId: components[last],
+	}, nil
+}
+
+func (s selfHostedReleaseLocation) String() string {
+	return fmt.Sprintf(
+		"%s%s/%s/%s/%s",
+		s.scheme,
+		strings.TrimPrefix(s.baseUrl, "https://"),
+		s.repo.Owner,
+		s.repo.Name,
+		s.releaseId)
+}
+
+// tokens are optional, since public repos don't need them
+func makeSelfHostedReleaseHost(
+	scheme string,
+	baseUrl string,
+	creds credentials.Provider,
+) (releasehost.ReleaseCreator, error) {
+	switch scheme {
+	case giteaReleaseScheme:
+		token, err := credentials.Optional(creds, "GITEA_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+
+		return releasehost.Gitea(baseUrl, token), nil
+	case gitlabReleaseScheme:
+		token, err := credentials.Optional(creds, "GITLAB_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+
+		return releasehost.GitLab(baseUrl, token), nil
+	default:
+		return nil, fmt.Errorf("unsupported release host: %s", scheme)
+	}
+}
+
+func newSelfHostedReleaseArtefactDownloader(
+	uri string,
+	creds credentials.Provider,
+) (*releaseHostArtefactDownloader, error) {
+	location, err := parseSelfHostedReleaseLocation(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	host, err := makeSelfHostedReleaseHost(location.scheme, location.baseUrl, creds)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReleaseHostArtefactDownloader(host, location.repo, location.releaseId), nil
+}
+
+func selfHostedReleaseMkEntry(scheme string, hostName string, logger *log.Logger) *cobra.Command {
+	units := releaseUnitFlags{}
+	steps := releaseSteps{}
+
+	cmd := &cobra.Command{
+		Use:   strings.TrimSuffix(scheme, ":") + "-mk [baseUrl] [owner] [repo] [releaseName] [revisionId] [assetDir]",
+		Short: "Create " + hostName + " release",
+		Args:  cobra.ExactArgs(6),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(func() error {
+				deployerSpecFilename, otherUnits, err := units.parse()
+				if err != nil {
+					return err
+				}
+
+				return createSelfHostedRelease(
+					ossignal.InterruptOrTerminateBackgroundCtx(logger),
+					scheme,
+					args[0],
+					releasehost.NewRepoRef(args[1], args[2]),
+					args[3],
+					args[4],
+					args[5],
+					deployerSpecFilename,
+					otherUnits,
+					steps,
+					logger,
+				)
+			}())
+		},
+	}
+
+	units.register(cmd)
+	cmd.Flags().BoolVarP(&steps.publish, "publish", "", steps.publish, "Publish the (draft) release once it's registered")
+
+	return cmd
+}
+
+func createSelfHostedRelease(
+	ctx context.Context,
+	scheme string,
+	baseUrl string,
+	repo releasehost.RepoRef,
+	releaseName string,
+	revisionId string,
+	assetsDir string,
+	deployerSpecFilename string,
+	units map[string]string, // other units than main
+	steps releaseSteps, // only publish is supported
+	logger *log.Logger,
+) error {
+	host, err := makeSelfHostedReleaseHost(scheme, baseUrl, credentialsProvider())
+	if err != nil {
+		return err
+	}
+
+	app, err := mkApp(ctx)
+	if err != nil {
+		return err
+	}
+
+	alreadyRegistered := app.State.HasRevisionId(revisionId)
+	if alreadyRegistered { // should not be considered an error
+		logger.Printf("WARN: already have revision %s", revisionId)
+
+		if !steps.publish { // publishing might've been what failed last time
+			return nil
+		}
+	}
+
+	// returns existing release if re-run
+	releaseId, err := host.CreateRelease(ctx, repo, releaseName, revisionId)
+	if err != nil {
+		return err
+	}
+
+	if !alreadyRegistered {
+		if err := uploadArtefactsToReleaseHost(ctx, assetsDir, host, repo, releaseId, logger); err != nil {
+			return err
+		}
+
+		checksums, err := checksumsOfDir(assetsDir)
+		if err != nil {
+			return err
+		}
+
+		location := selfHostedReleaseLocation{
+			scheme:    scheme,
+			baseUrl:   strings.TrimSuffix(baseUrl, "/"),
+			repo:      repo,
+			releaseId: releaseId,
+		}
+
+		releaseCreated := ddomain.NewReleaseCreated(
+			cryptorandombytes.Base64UrlWithoutLeadingDash(4),
+			repo.Owner+"/"+repo.Name,
+			releaseName,
+			revisionId,
+			location.String(),
+			deployerSpecFilename,
+			units,
+			checksums,
+			ehevent.MetaSystemUser(time.Now()))
+
+		if err := appendEvents(ctx, app, releaseCreated); err != nil {
+			return err
+		}
+	}
+
+	// only after registering, so a published release is always deployable
+	if steps.publish {
+		if err := host.PublishRelease(ctx, repo, releaseId); err != nil {
+			return fmt.Errorf("publish: %w", err)
+		}
+
+		logger.Printf("published release %s", releaseId)
+	}
+
+	return nil
+}
+
+// skips assets that a previous run already uploaded
+func uploadArtefactsToReleaseHost(
+	ctx context.Context,
+	assetsDir string,
+	host releasehost.ReleaseCreator,
+	repo releasehost.RepoRef,
+	releaseId string,
+	logger *log.Logger,
+) error {
+	existingAssets, err := host.ListAssets(ctx, repo, releaseId)
+	if err != nil {
+		return err
+	}
+
+	alreadyUploaded := map[string]bool{}
+	for _, asset := range existingAssets {
+		alreadyUploaded[asset.Name] = true
+	}
+
+	filePaths, err := filesInDir(assetsDir)
+	if err != nil {
+		return err
+	}
+
+	for _, filePath := range filePaths {
+		filename := filepath.Base(filePath)
+
+		if alreadyUploaded[filename] {
+			logger.Printf("%s already uploaded", filename)
+			continue
+		}
+
+		if err := func() error {
+			logger.Printf("uploading %s", filename)
+
+			file, err := os.Open(filePath)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			return host.UploadAsset(ctx, repo, releaseId, filename, file)
+		}(); err != nil {
+			return fmt.Errorf("upload %s: %w", filename, err)
+		}
+	}
+
+	return nil
+}
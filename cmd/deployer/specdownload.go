@@ -3,23 +3,41 @@ package main
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/function61/deployer/pkg/specsig"
 	"github.com/function61/gokit/jsonfile"
 )
 
-// same as extractSpec, but hides stupid buffering stuff required by io.ReaderAt
-func extractSpecFromReader(serviceId string, zipFile io.Reader) error {
+// same as extractSpec, but hides stupid buffering stuff required by io.ReaderAt.
+// if trustedKeys given, refuses to extract unless signature is from one of them.
+func extractSpecFromReader(
+	serviceId string,
+	zipFile io.Reader,
+	signature *specsig.Signature,
+	trustedKeys []string,
+) error {
 	buf := &bytes.Buffer{}
 
 	if _, err := io.Copy(buf, zipFile); err != nil {
 		return err
 	}
 
+	if len(trustedKeys) > 0 {
+		if signature == nil {
+			return errors.New("refusing to use unsigned deployer spec (trusted_spec_keys is configured)")
+		}
+
+		if err := specsig.Verify(buf.Bytes(), *signature, trustedKeys); err != nil {
+			return fmt.Errorf("refusing to use deployer spec: %w", err)
+		}
+	}
+
 	bufReader := bytes.NewReader(buf.Bytes())
 
 	return extractSpec(serviceId, bufReader, int64(bufReader.Len()))
@@ -96,9 +114,43 @@ func readAndValidateManifest(dir string) (*DeplSpecManifest, error) {
 		return nil, err
 	}
 
+	return manifest, validateManifest(manifest)
+}
+
+// reads manifest straight from deployerspec.zip content, without extracting
+func readAndValidateManifestFromSpec(zipContent []byte) (*DeplSpecManifest, error) {
+	zipReader, err := zip.NewReader(bytes.NewReader(zipContent), int64(len(zipContent)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range zipReader.File {
+		if file.Name != "manifest.json" {
+			continue
+		}
+
+		manifestReader, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer manifestReader.Close()
+
+		manifest := &DeplSpecManifest{}
+		if err := jsonfile.Unmarshal(manifestReader, manifest, true); err != nil {
+			return nil, fmt.Errorf("manifest.json: %w", err)
+		}
+
+		return manifest, validateManifest(manifest)
+	}
+
+	return nil, errors.New("manifest.json not found in deployer spec")
+}
+
+func validateManifest(manifest *DeplSpecManifest) error {
 	if manifest.ManifestVersionMajor != 1 {
-		return nil, fmt.Errorf("unsupported manifest version; got %d", manifest.ManifestVersionMajor)
+		return fmt.Errorf("unsupported manifest version; got %d", manifest.ManifestVersionMajor)
 	}
 
-	return manifest, nil
+	_, err := manifest.parsedTimeout()
+	return err
 }
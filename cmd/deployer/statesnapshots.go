@@ -0,0 +1,308 @@
+package main
+
+// State dir is the only stateful thing in a deployment, so it's snapshotted before each
+// deploy (and destroy, restore) to let us revert after a botched deploy:
+//   deployments/<serviceId>/state-snapshots/<timestamp>-before-<releaseId>.tar.zst
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/function61/deployer/pkg/dirarchive"
+	"github.com/function61/gokit/atomicfilewrite"
+	"github.com/function61/gokit/ossignal"
+	"github.com/klauspost/compress/zstd"
+	"github.com/scylladb/termtables"
+	"github.com/spf13/cobra"
+)
+
+const (
+	stateSnapshotExtension    = ".tar.zst"
+	defaultStateSnapshotsKeep = 10
+)
+
+func stateSnapshotPath(serviceId string, snapshotId string) string {
+	return filepath.Join(stateSnapshotsDir(serviceId), filepath.Base(snapshotId)+stateSnapshotExtension)
+}
+
+func stateSnapshotIdsNewestFirst(serviceId string) ([]string, error) {
+	return timestampedIdsNewestFirst(stateSnapshotsDir(serviceId), stateSnapshotExtension)
+}
+
+// keep is from user config (see UserConfig.StateSnapshots). returns "" if there was nothing
+// to snapshot (or snapshots are disabled).
+func snapshotState(serviceId string, label string, keep int) (string, error) {
+	switch {
+	case keep < 0:
+		return "", nil
+	case keep == 0:
+		keep = defaultStateSnapshotsKeep
+	}
+
+	empty, err := isEmptyOrNonExistentDir(stateDir(serviceId))
+	if err != nil || empty {
+		return "", err
+	}
+
+	snapshotId := timestampedId(time.Now(), label)
+
+	if err := os.MkdirAll(stateSnapshotsDir(serviceId), 0700); err != nil {
+		return "", err
+	}
+
+	if err := atomicfilewrite.Write(stateSnapshotPath(serviceId, snapshotId), func(snapshot io.Writer) error {
+		zstdWriter, err := zstd.NewWriter(snapshot)
+		if err != nil {
+			return err
+		}
+
+		if err := dirarchive.Create(zstdWriter, stateDir(serviceId)); err != nil {
+			zstdWriter.Close()
+			return err
+		}
+
+		return zstdWriter.Close()
+	}); err != nil {
+		return "", err
+	}
+
+	log.Printf("state snapshotted as %s", snapshotId)
+
+	return snapshotId, pruneStateSnapshots(serviceId, keep)
+}
+
+func pruneStateSnapshots(serviceId string, keep int) error {
+	snapshotIds, err := stateSnapshotIdsNewestFirst(serviceId)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshotIds) <= keep {
+		return nil
+	}
+
+	for _, snapshotId := range snapshotIds[keep:] {
+		if err := os.Remove(stateSnapshotPath(serviceId, snapshotId)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func extractStateSnapshot(serviceId string, snapshotId string, destination string) error {
+	snapshot, err := os.Open(stateSnapshotPath(serviceId, snapshotId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf(
+				"state snapshot not found: %s (list snapshots with $ %s state ls %s)",
+				snapshotId,
+				os.Args[0],
+				serviceId)
+		}
+
+		return err
+	}
+	defer snapshot.Close()
+
+	zstdReader, err := zstd.NewReader(snapshot)
+	if err != nil {
+		return err
+	}
+	defer zstdReader.Close()
+
+	return dirarchive.Extract(zstdReader, destination)
+}
+
+func restoreState(serviceId string, snapshotId string, keep int, assumeYes bool) error {
+	unlock, err := acquireDeploymentLock(serviceId)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if !assumeYes {
+		confirmed, err := promptConfirmation(fmt.Sprintf(
+			"Replace state of %s with snapshot %s",
+			serviceId,
+			snapshotId))
+		if err != nil {
+			return err
+		}
+
+		if !confirmed {
+			return errors.New("restore canceled")
+		}
+	}
+
+	// extract first, so a broken snapshot doesn't leave us without state
+	restoring := stateDir(serviceId) + ".restoring"
+	if err := os.RemoveAll(restoring); err != nil {
+		return err
+	}
+	defer os.RemoveAll(restoring)
+
+	if err := extractStateSnapshot(serviceId, snapshotId, restoring); err != nil {
+		return err
+	}
+
+	// makes the restore itself revertable
+	if _, err := snapshotState(serviceId, "before-restore", keep); err != nil {
+		return fmt.Errorf("snapshotting current state: %w", err)
+	}
+
+	if err := os.RemoveAll(stateDir(serviceId)); err != nil {
+		return err
+	}
+
+	if err := os.Rename(restoring, stateDir(serviceId)); err != nil {
+		return err
+	}
+
+	log.Printf("state restored from %s", snapshotId)
+
+	return nil
+}
+
+// compares snapshot to current state, or to another snapshot. uses diff(1).
+func diffState(ctx context.Context, serviceId string, snapshotId string, otherSnapshotId string) error {
+	tempDir, err := ioutil.TempDir("", "deployer-state-diff-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// diff output shows paths relative to tempDir, so name dirs by snapshot
+	if err := extractStateSnapshot(serviceId, snapshotId, filepath.Join(tempDir, snapshotId)); err != nil {
+		return err
+	}
+
+	other := stateDir(serviceId)
+	if otherSnapshotId != "" {
+		if err := extractStateSnapshot(serviceId, otherSnapshotId, filepath.Join(tempDir, otherSnapshotId)); err != nil {
+			return err
+		}
+
+		other = otherSnapshotId
+	}
+
+	//nolint:gosec // snapshot IDs are filename-safe
+	diff := exec.CommandContext(ctx, "diff", "-ruN", snapshotId, other)
+	diff.Dir = tempDir
+	diff.Stdout = os.Stdout
+	diff.Stderr = os.Stderr
+
+	// exit code 1 means differences were found
+	if err := diff.Run(); err != nil && exitCodeFromErr(err) != 1 {
+		return fmt.Errorf("diff: %w", err)
+	}
+
+	return nil
+}
+
+func listStateSnapshots(serviceId string) error {
+	snapshotIds, err := stateSnapshotIdsNewestFirst(serviceId)
+	if err != nil {
+		return err
+	}
+
+	snapshotsTbl := termtables.CreateTable()
+	snapshotsTbl.AddHeaders("Snapshot ID", "Created", "Size")
+
+	for _, snapshotId := range snapshotIds {
+		info, err := os.Stat(stateSnapshotPath(serviceId, snapshotId))
+		if err != nil {
+			return err
+		}
+
+		snapshotsTbl.AddRow(
+			snapshotId,
+			info.ModTime().Local().Format("Jan 02 @ 15:04"),
+			fmt.Sprintf("%.1f kB", float64(info.Size())/1024))
+	}
+
+	fmt.Println(snapshotsTbl.Render())
+
+	return nil
+}
+
+func isEmptyOrNonExistentDir(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return len(entries) == 0, nil
+}
+
+func stateEntry(logger *log.Logger) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Inspect and restore state snapshots",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "ls [serviceId]",
+		Short: "List state snapshots",
+		Args:  cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			exitWithErrorIfErr(listStateSnapshots(args[0]))
+		},
+	})
+
+	cmd.AddCommand(func() *cobra.Command {
+		assumeYes := false
+
+		restoreCmd := &cobra.Command{
+			Use:   "restore [serviceId] [snapshotId]",
+			Short: "Replace state with a snapshot (current state is snapshotted first)",
+			Args:  cobra.ExactArgs(2),
+			Run: func(_ *cobra.Command, args []string) {
+				exitWithErrorIfErr(func() error {
+					userConf, err := loadUserConfig(args[0])
+					if err != nil {
+						return err
+					}
+
+					return restoreState(args[0], args[1], userConf.StateSnapshots, assumeYes)
+				}())
+			},
+		}
+
+		restoreCmd.Flags().BoolVarP(&assumeYes, "yes", "y", assumeYes, "Don't ask for confirmation")
+
+		return restoreCmd
+	}())
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "diff [serviceId] [snapshotId] [otherSnapshotId]",
+		Short: "Show changes between snapshot and current state (or another snapshot)",
+		Args:  cobra.RangeArgs(2, 3),
+		Run: func(_ *cobra.Command, args []string) {
+			otherSnapshotId := ""
+			if len(args) == 3 {
+				otherSnapshotId = args[2]
+			}
+
+			exitWithErrorIfErr(diffState(
+				ossignal.InterruptOrTerminateBackgroundCtx(logger),
+				args[0],
+				args[1],
+				otherSnapshotId))
+		},
+	})
+
+	return cmd
+}
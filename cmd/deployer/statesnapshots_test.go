@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestStateSnapshots(t *testing.T) {
+	_, cleanup := chdirToTempDir(t)
+	defer cleanup()
+
+	tfstatePath := filepath.Join(stateDir("hq"), "terraform.tfstate")
+
+	writeState := func(content string) {
+		assert.Ok(t, ioutil.WriteFile(tfstatePath, []byte(content), 0600))
+	}
+
+	readState := func() string {
+		content, err := ioutil.ReadFile(tfstatePath)
+		assert.Ok(t, err)
+		return string(content)
+	}
+
+	// nothing to snapshot yet
+	snapshotId, err := snapshotState("hq", "before-id1", 2)
+	assert.Ok(t, err)
+	assert.EqualString(t, snapshotId, "")
+
+	assert.Ok(t, os.MkdirAll(stateDir("hq"), 0755))
+	writeState("v1")
+
+	// disabled
+	snapshotId, err = snapshotState("hq", "before-id2", -1)
+	assert.Ok(t, err)
+	assert.EqualString(t, snapshotId, "")
+
+	goodSnapshotId, err := snapshotState("hq", "before-id2", 2)
+	assert.Ok(t, err)
+
+	writeState("v2 (botched)")
+
+	assert.Ok(t, restoreState("hq", goodSnapshotId, 2, true))
+	assert.EqualString(t, readState(), "v1")
+
+	// restore snapshots the state it replaces
+	snapshotIds, err := stateSnapshotIdsNewestFirst("hq")
+	assert.Ok(t, err)
+	assert.Assert(t, len(snapshotIds) == 2)
+
+	// prunes the oldest when over retention count
+	newestSnapshotId, err := snapshotState("hq", "before-id3", 1)
+	assert.Ok(t, err)
+
+	snapshotIds, err = stateSnapshotIdsNewestFirst("hq")
+	assert.Ok(t, err)
+	assert.Assert(t, len(snapshotIds) == 1)
+	assert.EqualString(t, snapshotIds[0], newestSnapshotId)
+
+	assert.EqualString(t, restoreState("hq", goodSnapshotId, 2, true).Error(), fmt.Sprintf(
+		"state snapshot not found: %s (list snapshots with $ %s state ls hq)",
+		goodSnapshotId,
+		os.Args[0]))
+}
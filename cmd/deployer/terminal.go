@@ -0,0 +1,183 @@
+package main
+
+// TTY allocation for the deployer container. Unattended deploys (CI, cron) don't have a
+// terminal, so we can't ask Docker for one and their output is timestamped for logs.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// false also for /dev/null, which is what cron etc. give us as stdin
+func stdinIsTerminal() bool {
+	return isTerminal(os.Stdin)
+}
+
+func isTerminal(file *os.File) bool {
+	return term.IsTerminal(int(file.Fd()))
+}
+
+// --tty | --no-tty. without either, TTY is allocated if stdin is a terminal.
+type ttyFlags struct {
+	tty   bool
+	noTty bool
+}
+
+func (t *ttyFlags) register(cmd *cobra.Command) {
+	cmd.Flags().BoolVarP(&t.tty, "tty", "", t.tty, "Allocate TTY for the deployer container (default: if stdin is a terminal)")
+	cmd.Flags().BoolVarP(&t.noTty, "no-tty", "", t.noTty, "Don't allocate TTY for the deployer container")
+}
+
+func (t *ttyFlags) resolve() (bool, error) {
+	switch {
+	case t.tty && t.noTty:
+		return false, errors.New("--tty and --no-tty are mutually exclusive")
+	case t.tty:
+		return true, nil
+	case t.noTty:
+		return false, nil
+	default:
+		return stdinIsTerminal(), nil
+	}
+}
+
+// with TTY the output goes to the terminal as-is. otherwise stdin is not attached and
+// output lines are timestamped. if logOutput is given, output is also copied there
+// (always timestamped, stdout and stderr interleaved).
+func runWithOutput(cmd *exec.Cmd, tty bool, logOutput io.Writer) error {
+	wait, err := startWithOutput(cmd, tty, logOutput)
+	if err != nil {
+		return err
+	}
+
+	return wait()
+}
+
+// like runWithOutput(), but returns after starting. call wait() for the result.
+func startWithOutput(cmd *exec.Cmd, tty bool, logOutput io.Writer) (func() error, error) {
+	if tty && logOutput == nil {
+		redirectStandardStreams(cmd)
+
+		if err := cmd.Start(); err != nil {
+			return nil, err
+		}
+
+		return cmd.Wait, nil
+	}
+
+	timestampedWriters := []*timestampedLineWriter{}
+	timestamped := func(out io.Writer) io.Writer {
+		writer := newTimestampedLineWriter(out)
+		timestampedWriters = append(timestampedWriters, writer)
+		return writer
+	}
+
+	var stdout, stderr io.Writer
+	if tty {
+		cmd.Stdin = os.Stdin
+		stdout, stderr = os.Stdout, os.Stderr
+	} else {
+		stdout, stderr = timestamped(os.Stdout), timestamped(os.Stderr)
+	}
+
+	if logOutput != nil {
+		// stdout and stderr are copied from different goroutines
+		logOutputSynchronized := &synchronizedWriter{inner: logOutput}
+
+		stdout = io.MultiWriter(stdout, timestamped(logOutputSynchronized))
+		stderr = io.MultiWriter(stderr, timestamped(logOutputSynchronized))
+	}
+
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		errWait := cmd.Wait()
+
+		// last line might not have ended in newline
+		for _, writer := range timestampedWriters {
+			if err := writer.Flush(); err != nil && errWait == nil {
+				errWait = err
+			}
+		}
+
+		return errWait
+	}, nil
+}
+
+type synchronizedWriter struct {
+	inner io.Writer
+	mu    sync.Mutex
+}
+
+func (s *synchronizedWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inner.Write(p)
+}
+
+// prefixes each line with UTC timestamp. partial lines are buffered until newline or Flush()
+type timestampedLineWriter struct {
+	out     io.Writer
+	partial []byte
+	now     func() time.Time
+}
+
+func newTimestampedLineWriter(out io.Writer) *timestampedLineWriter {
+	return &timestampedLineWriter{
+		out: out,
+		now: time.Now,
+	}
+}
+
+func (t *timestampedLineWriter) Write(p []byte) (int, error) {
+	t.partial = append(t.partial, p...)
+
+	for {
+		newlineIdx := bytes.IndexByte(t.partial, '\n')
+		if newlineIdx == -1 {
+			break
+		}
+
+		if err := t.writeLine(t.partial[:newlineIdx]); err != nil {
+			return 0, err
+		}
+
+		t.partial = t.partial[newlineIdx+1:]
+	}
+
+	return len(p), nil
+}
+
+func (t *timestampedLineWriter) Flush() error {
+	if len(t.partial) == 0 {
+		return nil
+	}
+
+	defer func() { t.partial = nil }()
+
+	return t.writeLine(t.partial)
+}
+
+func (t *timestampedLineWriter) writeLine(line []byte) error {
+	_, err := fmt.Fprintf(
+		t.out,
+		"%s %s\n",
+		t.now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		bytes.TrimSuffix(line, []byte{'\r'}))
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestTimestampedLineWriter(t *testing.T) {
+	output := &bytes.Buffer{}
+
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	writer := newTimestampedLineWriter(output)
+	writer.now = func() time.Time {
+		t0 = t0.Add(time.Second)
+		return t0
+	}
+
+	fmt.Fprint(writer, "Initializing ")
+	fmt.Fprint(writer, "provider\r\nApply complete!\nno newline at end")
+
+	assert.EqualString(t, output.String(), `2020-02-20T14:02:01.000Z Initializing provider
+2020-02-20T14:02:02.000Z Apply complete!
+`)
+
+	assert.Ok(t, writer.Flush())
+	assert.Ok(t, writer.Flush()) // no-op
+
+	assert.EqualString(t, output.String(), `2020-02-20T14:02:01.000Z Initializing provider
+2020-02-20T14:02:02.000Z Apply complete!
+2020-02-20T14:02:03.000Z no newline at end
+`)
+}
+
+func TestTtyFlags(t *testing.T) {
+	resolve := func(flags ttyFlags) string {
+		tty, err := flags.resolve()
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("%v", tty)
+	}
+
+	assert.EqualString(t, resolve(ttyFlags{tty: true}), "true")
+	assert.EqualString(t, resolve(ttyFlags{noTty: true}), "false")
+	assert.EqualString(t, resolve(ttyFlags{tty: true, noTty: true}), "--tty and --no-tty are mutually exclusive")
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+// deployment dirs are relative to working dir, so tests that create them run in a temp
+// dir. returns the temp dir and func that changes back and removes it.
+func chdirToTempDir(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "deployer-test-")
+	assert.Ok(t, err)
+
+	workingDir, err := os.Getwd()
+	assert.Ok(t, err)
+	assert.Ok(t, os.Chdir(dir))
+
+	return dir, func() {
+		_ = os.Chdir(workingDir)
+		os.RemoveAll(dir)
+	}
+}
+
+// returns func that restores previous values
+func setEnvs(envs map[string]string) func() {
+	previous := map[string]*string{}
+
+	for key, value := range envs {
+		if prev, has := os.LookupEnv(key); has {
+			previous[key] = &prev
+		} else {
+			previous[key] = nil
+		}
+
+		os.Setenv(key, value)
+	}
+
+	return func() {
+		for key, prev := range previous {
+			if prev != nil {
+				os.Setenv(key, *prev)
+			} else {
+				os.Unsetenv(key)
+			}
+		}
+	}
+}
@@ -3,9 +3,10 @@ package main
 import (
 	"errors"
 	"fmt"
-	"regexp"
-	"strings"
+	"time"
 
+	"github.com/function61/deployer/pkg/ddomain"
+	"github.com/function61/deployer/pkg/dstate"
 	"github.com/function61/gokit/jsonfile"
 )
 
@@ -21,7 +22,8 @@ type EnvVarSpec struct {
 }
 
 type VersionFile struct {
-	FriendlyVersion string `json:"friendly_version"` // 20190107_1257_ec16791b
+	FriendlyVersion string                              `json:"friendly_version"`    // 20190107_1257_ec16791b
+	Checksums       map[string]ddomain.ArtefactChecksum `json:"checksums,omitempty"` // of manifest's download_artefacts
 }
 
 type DeplSpecManifest struct {
@@ -29,10 +31,31 @@ type DeplSpecManifest struct {
 	DeployerImage               string       `json:"deployer_image"`             // fn61/infrastructureascode:20190107_1257_ec16791b
 	DeployCommand               []string     `json:"deploy_command"`             // ["./deploy.sh"]
 	DeployInteractiveCommand    []string     `json:"deploy_interactive_command"` // defaults to ["/bin/bash"]
+	DestroyCommand              []string     `json:"destroy_command"`            // ["./destroy.sh"]. optional
+	PlanCommand                 []string     `json:"plan_command"`               // ["terraform", "plan"]. optional
 	DownloadArtefacts           []string     `json:"download_artefacts"`
 	DownloadArtefactUrlTemplate string       `json:"download_artefact_urltemplate"`
 	EnvVars                     []EnvVarSpec `json:"env_vars"`           // user configurable stuff
 	SoftwareUniqueId            string       `json:"software_unique_id"` // random UUID that should stay the same forever, used to prevent accidentally deploying wrong software
+	Timeout                     string       `json:"timeout,omitempty"`  // "45m". optional. applies to each command run in the deployer image
+}
+
+// 0 means no timeout
+func (d *DeplSpecManifest) parsedTimeout() (time.Duration, error) {
+	if d.Timeout == "" {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(d.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("manifest timeout: %w", err)
+	}
+
+	if timeout < 0 {
+		return 0, fmt.Errorf("manifest timeout cannot be negative: %s", d.Timeout)
+	}
+
+	return timeout, nil
 }
 
 type UserConfig struct {
@@ -40,6 +63,10 @@ type UserConfig struct {
 	Repository       string            `json:"repository"`
 	Envs             map[string]string `json:"envs"`
 	SoftwareUniqueId string            `json:"software_unique_id"`
+	TrustedSpecKeys  []string          `json:"trusted_spec_keys,omitempty"` // if set, deployer spec must be signed by one of these ("ed25519:...")
+	Unit             string            `json:"unit,omitempty"`              // deployable unit of the release. "" = main unit
+	StateSnapshots   int               `json:"state_snapshots,omitempty"`   // how many pre-deploy state snapshots to keep. 0 = default, -1 = disable
+	Runner           string            `json:"runner,omitempty"`            // what runs the deployer image: docker (default), podman, nerdctl or local
 }
 
 // below datatypes are not serialized
@@ -52,10 +79,16 @@ type VersionAndManifest struct {
 type Deployment struct {
 	Vam        VersionAndManifest
 	UserConfig UserConfig
+	ReleaseId  string // release ID or direct artefacts location
 
 	// computed
 	ExpandedDeployCommand            []string
 	ExpandedDeployInteractiveCommand []string
+	ExpandedDestroyCommand           []string
+	ExpandedPlanCommand              []string
+
+	Tty     bool          // allocate TTY for the deployer container
+	Timeout time.Duration // 0 = no timeout
 }
 
 // error is true for os.IsNotExist() if file not found
@@ -64,7 +97,8 @@ func loadUserConfig(serviceId string) (*UserConfig, error) {
 	return config, jsonfile.Read(userConfigPath(serviceId), config, true)
 }
 
-func validateUserConfig(user *UserConfig, vam *VersionAndManifest) (*Deployment, error) {
+// release is nil if deploying directly from artefacts location
+func validateUserConfig(user *UserConfig, vam *VersionAndManifest, release *dstate.SoftwareRelease) (*Deployment, error) {
 	knownKeys := map[string]bool{}
 
 	for _, env := range vam.Manifest.EnvVars {
@@ -94,66 +128,59 @@ func validateUserConfig(user *UserConfig, vam *VersionAndManifest) (*Deployment,
 			vam.Manifest.SoftwareUniqueId)
 	}
 
-	expandedDeployCommand := []string{}
-	for _, part := range vam.Manifest.DeployCommand {
-		partExpanded, err := expandPossibleVariables(part, vam, user)
-		if err != nil {
-			return nil, err
-		}
+	if _, err := containerRunnerByName(user.Runner); err != nil {
+		return nil, err
+	}
+
+	variables := deploymentVariables(vam, user, release)
 
-		expandedDeployCommand = append(expandedDeployCommand, partExpanded)
+	expandedDeployCommand, err := expandCommand(vam.Manifest.DeployCommand, variables)
+	if err != nil {
+		return nil, err
 	}
 
-	expandedDeployInteractiveCommand := []string{}
-	for _, part := range vam.Manifest.DeployInteractiveCommand {
-		partExpanded, err := expandPossibleVariables(part, vam, user)
-		if err != nil {
-			return nil, err
-		}
+	expandedDeployInteractiveCommand, err := expandCommand(vam.Manifest.DeployInteractiveCommand, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	expandedDestroyCommand, err := expandCommand(vam.Manifest.DestroyCommand, variables)
+	if err != nil {
+		return nil, err
+	}
 
-		expandedDeployInteractiveCommand = append(expandedDeployInteractiveCommand, partExpanded)
+	expandedPlanCommand, err := expandCommand(vam.Manifest.PlanCommand, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout, err := vam.Manifest.parsedTimeout()
+	if err != nil {
+		return nil, err
 	}
 
 	return &Deployment{
 		Vam:        *vam,
 		UserConfig: *user,
+		Timeout:    timeout,
 
 		ExpandedDeployCommand:            expandedDeployCommand,
 		ExpandedDeployInteractiveCommand: expandedDeployInteractiveCommand,
+		ExpandedDestroyCommand:           expandedDestroyCommand,
+		ExpandedPlanCommand:              expandedPlanCommand,
 	}, nil
 }
 
-var variableExpansionRe = regexp.MustCompile(`\$\{([^}]+)\}`)
-
-// "--version=${_.version.friendly}" => "--version=v314"
-func expandPossibleVariables(input string, versionAndManifest *VersionAndManifest, user *UserConfig) (string, error) {
-	expansion := variableExpansionRe.FindStringSubmatch(input)
-	if expansion == nil {
-		return input, nil
-	}
-
-	key := expansion[1]
-
-	replace, err := func() (string, error) {
-		switch {
-		case key == "_.version.friendly":
-			return versionAndManifest.Version.FriendlyVersion, nil
-		case strings.HasPrefix(key, "_.env."):
-			realKey := key[len("_.env."):]
-
-			val, found := user.Envs[realKey]
-			if !found {
-				return "", fmt.Errorf("no variable defined in UserConfig: %s", realKey)
-			}
-
-			return val, nil
-		default:
-			return "", fmt.Errorf("unknown expansion key: %s", expansion[1])
+func expandCommand(command []string, variables variableLookup) ([]string, error) {
+	expanded := []string{}
+	for _, part := range command {
+		partExpanded, err := expandPossibleVariables(part, variables)
+		if err != nil {
+			return nil, err
 		}
-	}()
-	if err != nil {
-		return "", err
+
+		expanded = append(expanded, partExpanded)
 	}
 
-	return strings.ReplaceAll(input, expansion[0], replace), nil
+	return expanded, nil
 }
@@ -30,11 +30,21 @@ func TestValidateUserConfig(t *testing.T) {
 			},
 			SoftwareUniqueId: "8386d692-97bb-47ef-a682-f7139172c240",
 		},
-	})
+	}, nil)
 	assert.Ok(t, err)
 
 	assert.EqualString(
 		t,
 		strings.Join(deployment.ExpandedDeployCommand, " "),
 		"deploy_website.sh --id myTestApp --version=v314")
+
+	_, err = validateUserConfig(&UserConfig{
+		SoftwareUniqueId: "8386d692-97bb-47ef-a682-f7139172c240",
+		Runner:           "lxc",
+	}, &VersionAndManifest{
+		Manifest: DeplSpecManifest{
+			SoftwareUniqueId: "8386d692-97bb-47ef-a682-f7139172c240",
+		},
+	}, nil)
+	assert.EqualString(t, err.Error(), "unsupported runner: lxc (supported: docker, podman, nerdctl, local)")
 }
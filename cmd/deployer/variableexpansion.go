@@ -0,0 +1,111 @@
+package main
+
+// Expands "${...}" placeholders in manifest's commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/function61/deployer/pkg/dstate"
+)
+
+// returns found=false if the key is known but has no value. error if the key is unknown.
+type variableLookup func(key string) (value string, found bool, err error)
+
+func deploymentVariables(vam *VersionAndManifest, user *UserConfig, release *dstate.SoftwareRelease) variableLookup {
+	fromRelease := func(get func(release dstate.SoftwareRelease) string) (string, bool, error) {
+		if release == nil { // deploying directly from artefacts location
+			return "", false, nil
+		}
+
+		return get(*release), true, nil
+	}
+
+	return func(key string) (string, bool, error) {
+		switch {
+		case key == "_.version.friendly":
+			return vam.Version.FriendlyVersion, true, nil
+		case key == "_.service.id":
+			return user.ServiceID, true, nil
+		case key == "_.release.id":
+			return fromRelease(func(r dstate.SoftwareRelease) string { return r.Id })
+		case key == "_.release.repository":
+			return fromRelease(func(r dstate.SoftwareRelease) string { return r.Repository })
+		case key == "_.release.revision_id":
+			return fromRelease(func(r dstate.SoftwareRelease) string { return r.RevisionId })
+		case key == "_.release.revision_friendly":
+			return fromRelease(func(r dstate.SoftwareRelease) string { return r.RevisionFriendly })
+		case strings.HasPrefix(key, "_.env."):
+			val, found := user.Envs[key[len("_.env."):]]
+			return val, found, nil
+		default:
+			return "", false, fmt.Errorf("unknown expansion key: %s", key)
+		}
+	}
+}
+
+// "--version=${_.version.friendly}" => "--version=v314"
+// "--tag=${_.env.region}-${_.version.friendly}" => "--tag=eu-central-1-v314"
+// "--region=${_.env.region:-us-east-1}" => default value if variable undefined or empty
+// "$${literal}" => "${literal}"
+func expandPossibleVariables(input string, variables variableLookup) (string, error) {
+	expanded := strings.Builder{}
+
+	for pos := 0; pos < len(input); {
+		switch {
+		case strings.HasPrefix(input[pos:], "$${"):
+			expanded.WriteString("${")
+			pos += len("$${")
+		case strings.HasPrefix(input[pos:], "${"):
+			placeholderLen := strings.IndexByte(input[pos:], '}')
+			if placeholderLen == -1 {
+				return "", fmt.Errorf("unterminated placeholder in: %s", input)
+			}
+
+			value, err := expandOnePlaceholder(input[pos+len("${"):pos+placeholderLen], variables)
+			if err != nil {
+				return "", err
+			}
+
+			expanded.WriteString(value)
+			pos += placeholderLen + 1
+		default:
+			expanded.WriteByte(input[pos])
+			pos++
+		}
+	}
+
+	return expanded.String(), nil
+}
+
+// "_.env.region:-us-east-1" => "us-east-1" if region is not defined
+func expandOnePlaceholder(expression string, variables variableLookup) (string, error) {
+	key := expression
+	defaultValue := ""
+	hasDefault := false
+
+	if idx := strings.Index(expression, ":-"); idx != -1 {
+		key = expression[:idx]
+		defaultValue = expression[idx+len(":-"):]
+		hasDefault = true
+	}
+
+	value, found, err := variables(key)
+	if err != nil {
+		return "", err
+	}
+
+	if found && value != "" {
+		return value, nil
+	}
+
+	if hasDefault {
+		return defaultValue, nil
+	}
+
+	if !found {
+		return "", fmt.Errorf("no value for variable: %s", key)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/function61/deployer/pkg/dstate"
+	"github.com/function61/gokit/assert"
+)
+
+func TestExpandPossibleVariables(t *testing.T) {
+	vam := &VersionAndManifest{
+		Version: VersionFile{
+			FriendlyVersion: "v314",
+		},
+	}
+
+	user := &UserConfig{
+		ServiceID: "hq",
+		Envs: map[string]string{
+			"region": "eu-central-1",
+			"empty":  "",
+		},
+	}
+
+	release := &dstate.SoftwareRelease{
+		Id:         "abc",
+		Repository: "function61/coolproduct",
+		RevisionId: "9c39d0271d0bd51c7ddfb55dc3051e68b6953c33",
+	}
+
+	tcs := []struct {
+		input  string
+		output string
+	}{
+		{"--version=${_.version.friendly}", "--version=v314"},
+		{"--tag=${_.env.region}-${_.version.friendly}", "--tag=eu-central-1-v314"},
+		{"${_.service.id}/${_.release.id}/${_.release.repository}", "hq/abc/function61/coolproduct"},
+		{"--rev=${_.release.revision_id}", "--rev=9c39d0271d0bd51c7ddfb55dc3051e68b6953c33"},
+		{"--zone=${_.env.zone:-a}", "--zone=a"},
+		{"--empty=${_.env.empty:-fallback}", "--empty=fallback"},
+		{"--region=${_.env.region:-us-east-1}", "--region=eu-central-1"},
+		{"--url=${_.env.zone:-http://x:8080/}", "--url=http://x:8080/"},
+		{"literal $${_.env.region} and $5", "literal ${_.env.region} and $5"},
+		{"no placeholders", "no placeholders"},
+		{"--empty=${_.env.empty}", "--empty="},
+		{"${_.env.nonexistent}", "ERROR: no value for variable: _.env.nonexistent"},
+		{"${_.foo}", "ERROR: unknown expansion key: _.foo"},
+		{"${_.env.region", "ERROR: unterminated placeholder in: ${_.env.region"},
+	}
+
+	for _, tc := range tcs {
+		tc := tc // pin
+		t.Run(tc.input, func(t *testing.T) {
+			output, err := expandPossibleVariables(tc.input, deploymentVariables(vam, user, release))
+			if err != nil {
+				output = "ERROR: " + err.Error()
+			}
+
+			assert.EqualString(t, output, tc.output)
+		})
+	}
+}
+
+func TestExpandReleaseVariablesWithoutRelease(t *testing.T) {
+	variables := deploymentVariables(&VersionAndManifest{}, &UserConfig{}, nil)
+
+	_, err := expandPossibleVariables("${_.release.id}", variables)
+	assert.EqualString(t, err.Error(), "no value for variable: _.release.id")
+
+	output, err := expandPossibleVariables("${_.release.id:-dev}", variables)
+	assert.Ok(t, err)
+	assert.EqualString(t, output, "dev")
+}
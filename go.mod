@@ -5,15 +5,18 @@ go 1.13
 require (
 	github.com/alessio/shellescape v1.2.1
 	github.com/apcera/termtables v0.0.0-20170405184538-bcbc5dc54055 // indirect
+	github.com/aws/aws-sdk-go v1.29.0
 	github.com/function61/certbus v0.0.0-20200216203021-466393a55bd8
 	github.com/function61/eventhorizon v0.2.1-0.20200227140656-f89fe5d462ca
 	github.com/function61/gokit v0.0.0-20200226141201-fe205250686d
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/inconshreveable/mousetrap v1.0.0
+	github.com/klauspost/compress v1.10.10
 	github.com/satori/go.uuid v1.2.0
 	github.com/scylladb/termtables v1.0.0
 	github.com/spf13/cobra v0.0.5
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
 )
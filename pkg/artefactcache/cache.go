@@ -0,0 +1,340 @@
+// Content-addressed local cache for downloaded artefacts, shared across services and releases
+package artefactcache
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/function61/gokit/atomicfilewrite"
+	"github.com/function61/gokit/jsonfile"
+)
+
+const (
+	metaSuffix    = ".meta.json"
+	lockSuffix    = ".lock"
+	partialSuffix = ".partial" // PutWith() writers' in-progress content
+)
+
+// where a cache entry came from. informational only.
+type Origin struct {
+	ArtefactsLocation string `json:"artefacts_location"`
+	Filename          string `json:"filename"`
+}
+
+type Entry struct {
+	Key      string
+	Size     int64
+	LastUsed time.Time
+	Origin   Origin
+	Partial  bool // interrupted (or ongoing) download that can be resumed
+}
+
+type Cache struct {
+	dir      string
+	pinnedMu sync.Mutex
+	pinned   map[string]int // key => pin count
+}
+
+func New(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		dir:    dir,
+		pinned: map[string]int{},
+	}, nil
+}
+
+// pinned entries are not evicted (by this process). pin before Get()/Put() and unpin
+// when you've opened the content. call the returned function to unpin.
+func (c *Cache) Pin(key string) func() {
+	c.pinnedMu.Lock()
+	defer c.pinnedMu.Unlock()
+
+	c.pinned[key]++
+
+	return func() {
+		c.pinnedMu.Lock()
+		defer c.pinnedMu.Unlock()
+
+		c.pinned[key]--
+		if c.pinned[key] == 0 {
+			delete(c.pinned, key)
+		}
+	}
+}
+
+func (c *Cache) isPinned(key string) bool {
+	c.pinnedMu.Lock()
+	defer c.pinnedMu.Unlock()
+
+	return c.pinned[key] > 0
+}
+
+// for content whose digest is known beforehand ("sha256:...")
+func KeyForDigest(digest string) string {
+	return strings.Replace(digest, ":", "-", 1)
+}
+
+// for content whose digest is not known. relies on artefacts location being immutable
+// (i.e. each release having a different location)
+func KeyForLocation(artefactsLocation string, filename string) string {
+	return fmt.Sprintf("location-%x", sha256.Sum256([]byte(artefactsLocation+"#"+filename)))
+}
+
+// returns path to cached content. marks the entry as used (for eviction purposes).
+func (c *Cache) Get(key string) (string, bool, error) {
+	path := c.path(key)
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	return path, true, nil
+}
+
+// stores content atomically (partial content never ends up in the cache)
+func (c *Cache) Put(key string, origin Origin, content io.Reader) (string, error) {
+	return c.PutWith(key, origin, func(destination string) error {
+		return atomicfilewrite.Write(destination, func(dest io.Writer) error {
+			_, err := io.Copy(dest, content)
+			return err
+		})
+	})
+}
+
+// for downloaders that write into a file by themselves. write must create destination
+// only when complete (it may use destination + ".partial" and files prefixed with it for
+// in-progress content, which is kept across failures so downloads can be resumed).
+//
+// writers of the same key (even in other processes) take turns, because their in-progress
+// files would collide. if the entry was stored while we waited, write is not called.
+func (c *Cache) PutWith(key string, origin Origin, write func(destination string) error) (string, error) {
+	path := c.path(key)
+
+	unlock, _, err := c.lock(key, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// before writing, so partial content can be listed with its origin
+	if err := jsonfile.Write(path+metaSuffix, &origin); err != nil {
+		return "", err
+	}
+
+	if err := write(path); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// exclusive lock for key. if wait is false and someone else holds the lock, returns
+// locked=false. lock files are never removed, because then a waiter could end up holding
+// a lock on a file that no longer exists.
+func (c *Cache) lock(key string, wait bool) (func(), bool, error) {
+	lockFile, err := os.OpenFile(c.path(key)+lockSuffix, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+
+	if err := syscall.Flock(int(lockFile.Fd()), how); err != nil {
+		lockFile.Close()
+
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, false, nil
+		}
+
+		return nil, false, fmt.Errorf("lock %s: %w", key, err)
+	}
+
+	return func() {
+		lockFile.Close() // releases the lock
+	}, true, nil
+}
+
+// least recently used first
+func (c *Cache) List() ([]Entry, error) {
+	dentries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+
+	for _, dentry := range dentries {
+		if dentry.IsDir() || isMetaOrInProgress(dentry.Name()) {
+			continue
+		}
+
+		key := strings.TrimSuffix(dentry.Name(), partialSuffix)
+
+		origin := Origin{}
+		if err := jsonfile.Read(c.path(key)+metaSuffix, &origin, false); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{
+			Key:      key,
+			Size:     dentry.Size(),
+			LastUsed: dentry.ModTime(),
+			Origin:   origin,
+			Partial:  key != dentry.Name(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+
+	return entries, nil
+}
+
+func (c *Cache) Remove(key string) error {
+	if err := os.Remove(c.path(key)); err != nil {
+		return err
+	}
+
+	if err := os.Remove(c.path(key) + metaSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// evicts least recently used entries until total size is at most maxSize. pinned entries
+// are skipped (so total can stay over maxSize). returns evicted entries.
+func (c *Cache) EvictToSize(maxSize int64) ([]Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	total := int64(0)
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	evicted := []Entry{}
+
+	for _, entry := range entries {
+		if total <= maxSize {
+			break
+		}
+
+		if c.isPinned(entry.Key) {
+			continue
+		}
+
+		if entry.Partial {
+			removed, err := c.removePartial(entry.Key)
+			if err != nil {
+				return evicted, err
+			}
+
+			if !removed { // download ongoing
+				continue
+			}
+		} else if err := c.Remove(entry.Key); err != nil {
+			return evicted, err
+		}
+
+		total -= entry.Size
+		evicted = append(evicted, entry)
+	}
+
+	return evicted, nil
+}
+
+// removes partial content of key unless someone is writing into it
+func (c *Cache) removePartial(key string) (bool, error) {
+	unlock, locked, err := c.lock(key, false)
+	if err != nil || !locked {
+		return false, err
+	}
+	defer unlock()
+
+	// writers' sidecar files (like "<key>.partial.validator") are removed along with it
+	sidecars, err := filepath.Glob(c.path(key) + partialSuffix + ".*")
+	if err != nil {
+		return false, err
+	}
+
+	for _, path := range append([]string{c.path(key) + partialSuffix, c.path(key) + metaSuffix}, sidecars...) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// .part = atomicfilewrite's in-progress file. partials are listed (they take up space), but
+// their sidecar files (like "<key>.partial.validator") are not.
+func isMetaOrInProgress(name string) bool {
+	for _, suffix := range []string{metaSuffix, lockSuffix, ".part"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+
+	return strings.Contains(name, partialSuffix+".")
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// "500M" => 500*1024*1024. supports K, M, G, T suffixes (binary multiples). plain number is bytes.
+func ParseSize(size string) (int64, error) {
+	multipliers := map[string]int64{
+		"K": 1 << 10,
+		"M": 1 << 20,
+		"G": 1 << 30,
+		"T": 1 << 40,
+	}
+
+	numberPart := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	multiplier := int64(1)
+
+	if len(numberPart) > 0 {
+		if m, found := multipliers[numberPart[len(numberPart)-1:]]; found {
+			multiplier = m
+			numberPart = numberPart[:len(numberPart)-1]
+		}
+	}
+
+	number, err := strconv.ParseInt(numberPart, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+
+	return number * multiplier, nil
+}
@@ -0,0 +1,224 @@
+package artefactcache
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestPutGetEvict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artefactcache-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir)
+	assert.Ok(t, err)
+
+	keyA := KeyForLocation("https://example.com/dl/v1/", "a.zip")
+	keyB := KeyForDigest("sha256:abcd")
+
+	assert.EqualString(t, keyB, "sha256-abcd")
+
+	_, found, err := cache.Get(keyA)
+	assert.Ok(t, err)
+	assert.Assert(t, !found)
+
+	_, err = cache.Put(keyA, Origin{"https://example.com/dl/v1/", "a.zip"}, strings.NewReader("aaaaaaaaaa"))
+	assert.Ok(t, err)
+	_, err = cache.Put(keyB, Origin{"docker://example.com/img:v1", "b.zip"}, strings.NewReader("bbbbb"))
+	assert.Ok(t, err)
+
+	// make A the least recently used even on filesystems with coarse timestamps
+	past := time.Now().Add(-time.Hour)
+	assert.Ok(t, os.Chtimes(cache.path(keyA), past, past))
+
+	entries, err := cache.List()
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 2)
+	assert.EqualString(t, entries[0].Key, keyA)
+	assert.EqualString(t, entries[0].Origin.Filename, "a.zip")
+	assert.Assert(t, entries[0].Size == 10)
+
+	path, found, err := cache.Get(keyB)
+	assert.Ok(t, err)
+	assert.Assert(t, found)
+
+	content, err := ioutil.ReadFile(path)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(content), "bbbbb")
+
+	evicted, err := cache.EvictToSize(10)
+	assert.Ok(t, err)
+	assert.Assert(t, len(evicted) == 1)
+	assert.EqualString(t, evicted[0].Key, keyA)
+
+	entries, err = cache.List()
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 1)
+	assert.EqualString(t, entries[0].Key, keyB)
+}
+
+func TestParseSize(t *testing.T) {
+	for input, expected := range map[string]int64{
+		"123":   123,
+		"1K":    1024,
+		"500M":  500 * 1024 * 1024,
+		"5GB":   5 * 1024 * 1024 * 1024,
+		"2g":    2 * 1024 * 1024 * 1024,
+		" 10 ":  10,
+		"1T":    1 << 40,
+		"0":     0,
+		"10MB ": 10 * 1024 * 1024,
+	} {
+		size, err := ParseSize(input)
+		assert.Ok(t, err)
+		assert.Assert(t, size == expected)
+	}
+
+	_, err := ParseSize("lots")
+	assert.EqualString(t, err.Error(), "invalid size: lots")
+}
+
+func TestPinnedNotEvicted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artefactcache-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir)
+	assert.Ok(t, err)
+
+	key := KeyForLocation("https://example.com/dl/v1/", "big.zip")
+
+	unpin := cache.Pin(key)
+	unpinAgain := cache.Pin(key) // concurrent user of the same entry
+
+	_, err = cache.Put(key, Origin{"https://example.com/dl/v1/", "big.zip"}, strings.NewReader("larger than max size"))
+	assert.Ok(t, err)
+
+	evicted, err := cache.EvictToSize(5)
+	assert.Ok(t, err)
+	assert.Assert(t, len(evicted) == 0)
+
+	unpin()
+
+	evicted, err = cache.EvictToSize(5)
+	assert.Ok(t, err)
+	assert.Assert(t, len(evicted) == 0)
+
+	unpinAgain()
+
+	evicted, err = cache.EvictToSize(5)
+	assert.Ok(t, err)
+	assert.Assert(t, len(evicted) == 1)
+}
+
+func TestConcurrentPutsOfSameKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artefactcache-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir)
+	assert.Ok(t, err)
+
+	key := KeyForDigest("sha256:abcd")
+
+	writes := 0
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// each writer uses the same in-progress file, so they must take turns
+			path, err := cache.PutWith(key, Origin{}, func(destination string) error {
+				writes++
+
+				if err := ioutil.WriteFile(destination+".partial", []byte("content"), 0644); err != nil {
+					return err
+				}
+
+				time.Sleep(10 * time.Millisecond)
+
+				return os.Rename(destination+".partial", destination)
+			})
+			assert.Ok(t, err)
+
+			content, err := ioutil.ReadFile(path)
+			assert.Ok(t, err)
+			assert.EqualString(t, string(content), "content")
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Assert(t, writes == 1)
+
+	entries, err := cache.List()
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 1)
+}
+
+func TestPartialsAreListedAndEvicted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artefactcache-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir)
+	assert.Ok(t, err)
+
+	key := KeyForLocation("https://example.com/dl/v1/", "a.zip")
+
+	writePartial := func(destination string) error {
+		if err := ioutil.WriteFile(destination+".partial", []byte("aaaaa"), 0644); err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(destination+".partial.validator", []byte(`"etag"`), 0644)
+	}
+
+	// interrupted download
+	_, err = cache.PutWith(key, Origin{"https://example.com/dl/v1/", "a.zip"}, func(destination string) error {
+		if err := writePartial(destination); err != nil {
+			return err
+		}
+
+		return errors.New("connection reset")
+	})
+	assert.EqualString(t, err.Error(), "connection reset")
+
+	entries, err := cache.List()
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 1)
+	assert.EqualString(t, entries[0].Key, key)
+	assert.EqualString(t, entries[0].Origin.Filename, "a.zip")
+	assert.Assert(t, entries[0].Partial)
+	assert.Assert(t, entries[0].Size == 5)
+
+	// partial that is being written into is not evicted
+	_, err = cache.PutWith(key, Origin{}, func(destination string) error {
+		evicted, err := cache.EvictToSize(0)
+		assert.Ok(t, err)
+		assert.Assert(t, len(evicted) == 0)
+
+		return errors.New("connection reset")
+	})
+	assert.EqualString(t, err.Error(), "connection reset")
+
+	evicted, err := cache.EvictToSize(0)
+	assert.Ok(t, err)
+	assert.Assert(t, len(evicted) == 1)
+
+	// lock file is all that's left
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	assert.Ok(t, err)
+	assert.Assert(t, len(files) == 1)
+	assert.EqualString(t, filepath.Base(files[0]), key+".lock")
+}
@@ -0,0 +1,239 @@
+// Resolves credentials (like API tokens) by name from multiple sources: environment,
+// credentials file, OS keyring and an external credential helper command.
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/function61/gokit/jsonfile"
+)
+
+type Provider interface {
+	// found=false (without error) if this provider doesn't know the credential
+	Lookup(name string) (value string, found bool, err error)
+	Describe() string // for error messages ("env", "keyring" etc.)
+}
+
+// returns error if none of the providers have the credential
+func Required(provider Provider, name string) (string, error) {
+	value, found, err := provider.Lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("credential %s: %w", name, err)
+	}
+
+	if !found {
+		return "", fmt.Errorf("credential %s not found (looked from: %s)", name, provider.Describe())
+	}
+
+	return value, nil
+}
+
+// returns "" if not found
+func Optional(provider Provider, name string) (string, error) {
+	value, _, err := provider.Lookup(name)
+	if err != nil {
+		return "", fmt.Errorf("credential %s: %w", name, err)
+	}
+
+	return value, nil
+}
+
+// ------
+
+type chain []Provider
+
+// asks providers in order. first one that has the credential wins.
+func Chain(providers ...Provider) Provider {
+	return chain(providers)
+}
+
+func (c chain) Lookup(name string) (string, bool, error) {
+	for _, provider := range c {
+		value, found, err := provider.Lookup(name)
+		if err != nil || found {
+			return value, found, err
+		}
+	}
+
+	return "", false, nil
+}
+
+func (c chain) Describe() string {
+	descriptions := []string{}
+	for _, provider := range c {
+		descriptions = append(descriptions, provider.Describe())
+	}
+
+	return strings.Join(descriptions, ", ")
+}
+
+// ------
+
+type env struct{}
+
+// credential name is the ENV var name
+func Env() Provider {
+	return env{}
+}
+
+func (e env) Lookup(name string) (string, bool, error) {
+	value := os.Getenv(name)
+	return value, value != "", nil
+}
+
+func (e env) Describe() string {
+	return "env"
+}
+
+// ------
+
+type file struct {
+	path string
+}
+
+// JSON file with {"GITHUB_TOKEN": "..."}. non-existent file is not an error.
+func File(path string) Provider {
+	return &file{path}
+}
+
+func (f *file) Lookup(name string) (string, bool, error) {
+	values := map[string]string{}
+	if err := jsonfile.Read(f.path, &values, true); err != nil {
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+
+		return "", false, err
+	}
+
+	value, found := values[name]
+	return value, found && value != "", nil
+}
+
+func (f *file) Describe() string {
+	return "file " + f.path
+}
+
+// ------
+
+type keyring struct {
+	service string
+}
+
+// Linux: libsecret ("$ secret-tool store --label=... service <service> name <name>")
+// macOS: Keychain ("$ security add-generic-password -s <service> -a <name> -w")
+func Keyring(service string) Provider {
+	return &keyring{service}
+}
+
+// tool can hang e.g. waiting for keyring unlock prompt that nobody sees
+const keyringLookupTimeout = 10 * time.Second
+
+func (k *keyring) Lookup(name string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyringLookupTimeout)
+	defer cancel()
+
+	argv, isNotFound := keyringLookupCommand(k.service, name)
+
+	return runKeyringTool(ctx, argv, isNotFound)
+}
+
+// returns command and whether its failure means that the credential was not found
+func keyringLookupCommand(service string, name string) ([]string, func(exitCode int, stderr string) bool) {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{"security", "find-generic-password", "-s", service, "-a", name, "-w"},
+			func(exitCode int, _ string) bool {
+				return exitCode == 44 // errSecItemNotFound
+			}
+	default:
+		// also exits with 1 on other errors (like no D-Bus), but then tells why in stderr
+		return []string{"secret-tool", "lookup", "service", service, "name", name},
+			func(exitCode int, stderr string) bool {
+				return exitCode == 1 && stderr == ""
+			}
+	}
+}
+
+func runKeyringTool(
+	ctx context.Context,
+	argv []string,
+	isNotFound func(exitCode int, stderr string) bool,
+) (string, bool, error) {
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		stderrMsg := strings.TrimSpace(stderr.String())
+		exitErr := &exec.ExitError{}
+
+		switch {
+		case errors.Is(err, exec.ErrNotFound): // tool not installed => no keyring to look from
+			return "", false, nil
+		case ctx.Err() != nil:
+			return "", false, fmt.Errorf("keyring: %s timed out (keyring locked?)", argv[0])
+		case errors.As(err, &exitErr) && isNotFound(exitErr.ExitCode(), stderrMsg):
+			return "", false, nil
+		case stderrMsg != "":
+			return "", false, fmt.Errorf("keyring: %w: %s", err, stderrMsg)
+		default:
+			return "", false, fmt.Errorf("keyring: %w", err)
+		}
+	}
+
+	value := strings.TrimRight(string(output), "\r\n")
+	return value, value != "", nil
+}
+
+func (k *keyring) Describe() string {
+	return "keyring"
+}
+
+// ------
+
+type helper struct {
+	command string
+}
+
+// runs "<command> get <name>" and uses its stdout as the credential. empty output means
+// not found. command is split by spaces (no shell quoting), like "pass-helper --store=ci".
+func Helper(command string) Provider {
+	return &helper{command}
+}
+
+func (h *helper) Lookup(name string) (string, bool, error) {
+	args := append(strings.Fields(h.command), "get", name)
+
+	stderr := &bytes.Buffer{}
+
+	//nolint:gosec // command is from user's config
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		if stderrMsg := strings.TrimSpace(stderr.String()); stderrMsg != "" {
+			return "", false, fmt.Errorf("credential helper: %w: %s", err, stderrMsg)
+		}
+
+		return "", false, fmt.Errorf("credential helper: %w", err)
+	}
+
+	value := strings.TrimRight(string(output), "\r\n")
+	return value, value != "", nil
+}
+
+func (h *helper) Describe() string {
+	return "helper " + h.command
+}
@@ -0,0 +1,98 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	credentialsPath := filepath.Join(dir, "credentials.json")
+	assert.Ok(t, ioutil.WriteFile(credentialsPath, []byte(`{"GITEA_TOKEN": "fromfile", "GITLAB_TOKEN": "fromfile"}`), 0600))
+
+	// prints "fromhelper-<name>" for GITHUB_TOKEN only
+	helperPath := filepath.Join(dir, "helper.sh")
+	assert.Ok(t, ioutil.WriteFile(helperPath, []byte(`#!/bin/sh
+[ "$1" = "get" ] || exit 1
+[ "$2" = "GITHUB_TOKEN" ] && echo "fromhelper-$2"
+exit 0
+`), 0700))
+
+	os.Setenv("CREDENTIALS_TEST_TOKEN", "fromenv")
+	defer os.Unsetenv("CREDENTIALS_TEST_TOKEN")
+
+	creds := Chain(
+		Env(),
+		File(credentialsPath),
+		Helper(helperPath))
+
+	lookup := func(name string) string {
+		value, err := Required(creds, name)
+		if err != nil {
+			return err.Error()
+		}
+
+		return value
+	}
+
+	assert.EqualString(t, lookup("CREDENTIALS_TEST_TOKEN"), "fromenv")
+	assert.EqualString(t, lookup("GITEA_TOKEN"), "fromfile")
+	assert.EqualString(t, lookup("GITHUB_TOKEN"), "fromhelper-GITHUB_TOKEN")
+	assert.EqualString(t, lookup("AWS_TOKEN"), "credential AWS_TOKEN not found (looked from: env, file "+credentialsPath+", helper "+helperPath+")")
+
+	optional, err := Optional(creds, "AWS_TOKEN")
+	assert.Ok(t, err)
+	assert.EqualString(t, optional, "")
+}
+
+func TestFileNotExists(t *testing.T) {
+	_, found, err := File("/nonexistent/credentials.json").Lookup("GITHUB_TOKEN")
+	assert.Ok(t, err)
+	assert.Assert(t, !found)
+}
+
+func TestHelperFails(t *testing.T) {
+	_, err := Required(Helper("false"), "GITHUB_TOKEN")
+	assert.EqualString(t, err.Error(), "credential GITHUB_TOKEN: credential helper: exit status 1")
+}
+
+func TestKeyringTool(t *testing.T) {
+	lookup := func(ctx context.Context, script string) string {
+		value, found, err := runKeyringTool(ctx, []string{"sh", "-c", script}, func(exitCode int, stderr string) bool {
+			return exitCode == 1 && stderr == ""
+		})
+		switch {
+		case err != nil:
+			return err.Error()
+		case !found:
+			return "(not found)"
+		default:
+			return value
+		}
+	}
+
+	ctx := context.Background()
+
+	assert.EqualString(t, lookup(ctx, "echo hunter2"), "hunter2")
+	assert.EqualString(t, lookup(ctx, "exit 1"), "(not found)")
+	assert.EqualString(t, lookup(ctx, "echo 'Cannot autolaunch D-Bus' >&2; exit 1"), "keyring: exit status 1: Cannot autolaunch D-Bus")
+	assert.EqualString(t, lookup(ctx, "exit 2"), "keyring: exit status 2")
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+
+	assert.EqualString(t, lookup(ctx, "exec sleep 5"), "keyring: sh timed out (keyring locked?)")
+
+	_, found, err := runKeyringTool(context.Background(), []string{"deployer-test-nonexistent-tool"}, nil)
+	assert.Ok(t, err)
+	assert.Assert(t, !found)
+}
@@ -2,11 +2,17 @@
 package ddomain
 
 import (
+	"time"
+
 	"github.com/function61/eventhorizon/pkg/ehevent"
 )
 
 var Types = ehevent.Allocators{
-	"ReleaseCreated": func() ehevent.Event { return &ReleaseCreated{} },
+	"ReleaseCreated":      func() ehevent.Event { return &ReleaseCreated{} },
+	"DeploymentStarted":   func() ehevent.Event { return &DeploymentStarted{} },
+	"DeploymentSucceeded": func() ehevent.Event { return &DeploymentSucceeded{} },
+	"DeploymentFailed":    func() ehevent.Event { return &DeploymentFailed{} },
+	"ServiceDestroyed":    func() ehevent.Event { return &ServiceDestroyed{} },
 }
 
 // ------
@@ -17,8 +23,15 @@ type ReleaseCreated struct {
 	Repository           string
 	RevisionFriendly     string
 	RevisionId           string
-	ArtefactsLocation    string // "https://baseurl" if easy to download. "githubrelease:owner:reponame:releaseId" for GitHub releases. "docker://<image ref>" for (container) images.
-	DeployerSpecFilename string `json:",omitempty"` // usually "deployerspec.zip"
+	ArtefactsLocation    string                      // "https://baseurl" if easy to download. "githubrelease:owner:reponame:releaseId" for GitHub releases. "docker://<image ref>" for (container) images.
+	DeployerSpecFilename string                      `json:",omitempty"` // usually "deployerspec.zip"
+	Units                map[string]string           `json:",omitempty"` // additional deployable units => their deployer spec filename
+	Checksums            map[string]ArtefactChecksum `json:",omitempty"` // keyed by filename
+}
+
+type ArtefactChecksum struct {
+	Sha256 string `json:"sha256"` // hex
+	Size   int64  `json:"size"`
 }
 
 func (e *ReleaseCreated) MetaType() string         { return "ReleaseCreated" }
@@ -31,6 +44,8 @@ func NewReleaseCreated(
 	revisionId string,
 	artefactsLocation string,
 	deployerSpecFilename string,
+	units map[string]string,
+	checksums map[string]ArtefactChecksum,
 	meta ehevent.EventMeta,
 ) *ReleaseCreated {
 	return &ReleaseCreated{
@@ -41,5 +56,119 @@ func NewReleaseCreated(
 		RevisionId:           revisionId,
 		ArtefactsLocation:    artefactsLocation,
 		DeployerSpecFilename: deployerSpecFilename,
+		Units:                units,
+		Checksums:            checksums,
+	}
+}
+
+// ------
+
+// operator who ran the deployment is recorded in meta's user ID
+type DeploymentStarted struct {
+	meta             ehevent.EventMeta
+	Id               string
+	ServiceId        string
+	ReleaseId        string // release ID or direct artefacts location ("https://example.com/dl/#deployerspec.zip")
+	Unit             string `json:",omitempty"` // "" = main deployment unit
+	SoftwareUniqueId string `json:",omitempty"`
+	Rollback         bool   `json:",omitempty"` // redeploy of a previously deployed release
+}
+
+func (e *DeploymentStarted) MetaType() string         { return "DeploymentStarted" }
+func (e *DeploymentStarted) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeploymentStarted(
+	id string,
+	serviceId string,
+	releaseId string,
+	unit string,
+	softwareUniqueId string,
+	rollback bool,
+	meta ehevent.EventMeta,
+) *DeploymentStarted {
+	return &DeploymentStarted{
+		meta:             meta,
+		Id:               id,
+		ServiceId:        serviceId,
+		ReleaseId:        releaseId,
+		Unit:             unit,
+		SoftwareUniqueId: softwareUniqueId,
+		Rollback:         rollback,
+	}
+}
+
+// ------
+
+type DeploymentSucceeded struct {
+	meta     ehevent.EventMeta
+	Id       string // refers to DeploymentStarted.Id
+	Duration time.Duration
+}
+
+func (e *DeploymentSucceeded) MetaType() string         { return "DeploymentSucceeded" }
+func (e *DeploymentSucceeded) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeploymentSucceeded(
+	id string,
+	duration time.Duration,
+	meta ehevent.EventMeta,
+) *DeploymentSucceeded {
+	return &DeploymentSucceeded{
+		meta:     meta,
+		Id:       id,
+		Duration: duration,
+	}
+}
+
+// ------
+
+type DeploymentFailed struct {
+	meta     ehevent.EventMeta
+	Id       string // refers to DeploymentStarted.Id
+	ExitCode int    // -1 if deploy command didn't get to exit by itself
+	Duration time.Duration
+	Error    string
+}
+
+func (e *DeploymentFailed) MetaType() string         { return "DeploymentFailed" }
+func (e *DeploymentFailed) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewDeploymentFailed(
+	id string,
+	exitCode int,
+	duration time.Duration,
+	errorMessage string,
+	meta ehevent.EventMeta,
+) *DeploymentFailed {
+	return &DeploymentFailed{
+		meta:     meta,
+		Id:       id,
+		ExitCode: exitCode,
+		Duration: duration,
+		Error:    errorMessage,
+	}
+}
+
+// ------
+
+// resources used by the service were torn down with the manifest's destroy command
+type ServiceDestroyed struct {
+	meta      ehevent.EventMeta
+	ServiceId string
+	ReleaseId string // release whose destroy command was used
+}
+
+func (e *ServiceDestroyed) MetaType() string         { return "ServiceDestroyed" }
+func (e *ServiceDestroyed) Meta() *ehevent.EventMeta { return &e.meta }
+
+func NewServiceDestroyed(
+	serviceId string,
+	releaseId string,
+	meta ehevent.EventMeta,
+) *ServiceDestroyed {
+	return &ServiceDestroyed{
+		meta:      meta,
+		ServiceId: serviceId,
+		ReleaseId: releaseId,
 	}
 }
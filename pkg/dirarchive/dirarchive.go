@@ -0,0 +1,206 @@
+// Archives a directory tree as a tar stream, and extracts it back
+package dirarchive
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writes contents of dir as tar into output. paths in the archive are relative to dir.
+// exclude lists paths (relative to dir, like "sub/file.txt") to leave out.
+// caller is responsible for compression.
+func Create(output io.Writer, dir string, exclude ...string) error {
+	tarWriter := tar.NewWriter(output)
+
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if relPath == "." {
+			return nil
+		}
+
+		for _, excluded := range exclude {
+			if filepath.ToSlash(relPath) == excluded {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tarWriter, file)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	return tarWriter.Close()
+}
+
+// extracts tar stream (as written by Create()) into dir, which is created if it doesn't
+// exist. caller is responsible for decompression.
+func Extract(input io.Reader, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	dir = filepath.Clean(dir)
+
+	// applied after extraction, because writing directory's children would change its mtime
+	type dirTime struct {
+		path    string
+		modTime time.Time
+	}
+	dirTimes := []dirTime{}
+
+	tarReader := tar.NewReader(input)
+
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return err
+		}
+
+		// guard against "../../etc/passwd"
+		path := filepath.Join(dir, filepath.FromSlash(header.Name))
+		if path == dir { // "./"
+			continue
+		}
+		if !isWithin(dir, path) {
+			return fmt.Errorf("archive entry outside of target dir: %s", header.Name)
+		}
+
+		// guard against "a -> /etc" followed by "a/passwd"
+		if err := ensureNoSymlinksInPath(dir, path); err != nil {
+			return err
+		}
+
+		mode := header.FileInfo().Mode()
+
+		// archives don't necessarily have entries for all parent dirs
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, mode.Perm()); err != nil {
+				return err
+			}
+
+			dirTimes = append(dirTimes, dirTime{path, header.ModTime})
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !isWithin(dir, filepath.Join(filepath.Dir(path), header.Linkname)) {
+				return fmt.Errorf("archive symlink points outside of target dir: %s -> %s", header.Name, header.Linkname)
+			}
+
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractFile(tarReader, path, mode.Perm()); err != nil {
+				return err
+			}
+
+			if err := os.Chtimes(path, header.ModTime, header.ModTime); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported archive entry type %c: %s", header.Typeflag, header.Name)
+		}
+	}
+
+	// deepest first, though setting parent's time doesn't touch children's
+	for i := len(dirTimes) - 1; i >= 0; i-- {
+		if err := os.Chtimes(dirTimes[i].path, dirTimes[i].modTime, dirTimes[i].modTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lexical check. path must be clean
+func isWithin(dir string, path string) bool {
+	return strings.HasPrefix(filepath.Clean(path), dir+string(os.PathSeparator))
+}
+
+// path and its parents (up to dir) must not be symlinks, since writing through them could
+// escape dir. non-existent components are fine (they're created by us).
+func ensureNoSymlinksInPath(dir string, path string) error {
+	for current := path; current != dir; current = filepath.Dir(current) {
+		info, err := os.Lstat(current)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to write through symlink: %s", current)
+		}
+	}
+
+	return nil
+}
+
+func extractFile(content io.Reader, path string, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, content); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
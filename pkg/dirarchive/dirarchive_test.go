@@ -0,0 +1,173 @@
+package dirarchive
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestCreateAndExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirarchive-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	assert.Ok(t, os.MkdirAll(filepath.Join(source, "sub"), 0755))
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(source, "terraform.tfstate"), []byte("state"), 0600))
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(source, "sub", "nested.txt"), []byte("nested"), 0644))
+	assert.Ok(t, os.Symlink("terraform.tfstate", filepath.Join(source, "link")))
+
+	archive := &bytes.Buffer{}
+	assert.Ok(t, Create(archive, source))
+
+	extracted := filepath.Join(dir, "extracted")
+	assert.Ok(t, Extract(archive, extracted))
+
+	readFile := func(path string) string {
+		content, err := ioutil.ReadFile(filepath.Join(extracted, path))
+		assert.Ok(t, err)
+		return string(content)
+	}
+
+	assert.EqualString(t, readFile("terraform.tfstate"), "state")
+	assert.EqualString(t, readFile("sub/nested.txt"), "nested")
+	assert.EqualString(t, readFile("link"), "state")
+
+	info, err := os.Stat(filepath.Join(extracted, "terraform.tfstate"))
+	assert.Ok(t, err)
+	assert.Assert(t, info.Mode().Perm() == 0600)
+}
+
+func TestCreateExcludes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirarchive-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "source")
+	assert.Ok(t, os.MkdirAll(filepath.Join(source, "sub", "cache"), 0755))
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(source, "deploy.lock"), []byte("lock"), 0644))
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(source, "sub", "kept.txt"), []byte("kept"), 0644))
+	assert.Ok(t, ioutil.WriteFile(filepath.Join(source, "sub", "cache", "big.bin"), []byte("big"), 0644))
+
+	archive := &bytes.Buffer{}
+	assert.Ok(t, Create(archive, source, "deploy.lock", "sub/cache"))
+
+	extracted := filepath.Join(dir, "extracted")
+	assert.Ok(t, Extract(archive, extracted))
+
+	exists := func(path string) bool {
+		_, err := os.Lstat(filepath.Join(extracted, path))
+		return err == nil
+	}
+
+	assert.Assert(t, !exists("deploy.lock"))
+	assert.Assert(t, exists("sub/kept.txt"))
+	assert.Assert(t, !exists("sub/cache"))
+}
+
+func TestExtractRejectsPathTraversal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirarchive-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	archive := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(archive)
+	assert.Ok(t, tarWriter.WriteHeader(&tar.Header{
+		Name:     "../escaped",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+	}))
+	assert.Ok(t, tarWriter.Close())
+
+	assert.EqualString(
+		t,
+		Extract(archive, filepath.Join(dir, "target")).Error(),
+		"archive entry outside of target dir: ../escaped")
+}
+
+func TestExtractRejectsSymlinkEscapes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirarchive-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	outside := filepath.Join(dir, "outside")
+	assert.Ok(t, os.MkdirAll(outside, 0755))
+
+	extractErr := func(target string, entries ...*tar.Header) string {
+		archive := &bytes.Buffer{}
+		tarWriter := tar.NewWriter(archive)
+		for _, entry := range entries {
+			assert.Ok(t, tarWriter.WriteHeader(entry))
+		}
+		assert.Ok(t, tarWriter.Close())
+
+		if err := Extract(archive, target); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+
+	symlink := func(name string, target string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeSymlink, Linkname: target, Mode: 0777}
+	}
+	file := func(name string) *tar.Header {
+		return &tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}
+	}
+
+	assert.EqualString(
+		t,
+		extractErr(filepath.Join(dir, "target1"), symlink("a", outside), file("a/passwd")),
+		"archive symlink points outside of target dir: a -> "+outside)
+
+	assert.EqualString(
+		t,
+		extractErr(filepath.Join(dir, "target2"), symlink("a", "../outside"), file("a/passwd")),
+		"archive symlink points outside of target dir: a -> ../outside")
+
+	// symlink that already exists in target dir
+	target3 := filepath.Join(dir, "target3")
+	assert.Ok(t, os.MkdirAll(target3, 0755))
+	assert.Ok(t, os.Symlink(outside, filepath.Join(target3, "a")))
+
+	assert.EqualString(
+		t,
+		extractErr(target3, file("a/passwd")),
+		"refusing to write through symlink: "+filepath.Join(target3, "a"))
+
+	// links within target dir are fine
+	assert.EqualString(t, extractErr(filepath.Join(dir, "target4"), file("file"), symlink("sub/link", "../file")), "")
+
+	entries, err := ioutil.ReadDir(outside)
+	assert.Ok(t, err)
+	assert.Assert(t, len(entries) == 0)
+}
+
+func TestExtractKeepsDirectoryTimes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirarchive-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	archive := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(archive)
+	assert.Ok(t, tarWriter.WriteHeader(&tar.Header{Name: "sub/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: t0}))
+	assert.Ok(t, tarWriter.WriteHeader(&tar.Header{Name: "sub/file", Typeflag: tar.TypeReg, Mode: 0644, ModTime: t0.Add(time.Hour)}))
+	assert.Ok(t, tarWriter.Close())
+
+	assert.Ok(t, Extract(archive, dir))
+
+	subInfo, err := os.Stat(filepath.Join(dir, "sub"))
+	assert.Ok(t, err)
+	assert.Assert(t, subInfo.ModTime().Equal(t0))
+
+	fileInfo, err := os.Stat(filepath.Join(dir, "sub", "file"))
+	assert.Ok(t, err)
+	assert.Assert(t, fileInfo.ModTime().Equal(t0.Add(time.Hour)))
+}
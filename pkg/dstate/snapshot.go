@@ -0,0 +1,57 @@
+package dstate
+
+// Local on-disk copy of the projected state, so releases can be listed and resolved
+// while Event Horizon is not reachable (or not configured at all)
+
+import (
+	"log"
+	"time"
+
+	"github.com/function61/gokit/jsonfile"
+	"github.com/function61/gokit/logex"
+)
+
+type snapshot struct {
+	Saved       time.Time         `json:"saved"`
+	Releases    []SoftwareRelease `json:"releases"`
+	Deployments []Deployment      `json:"deployments"`
+}
+
+func (c *Store) SaveSnapshot(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return jsonfile.Write(path, &snapshot{
+		Saved:       time.Now().UTC(),
+		Releases:    c.releases,
+		Deployments: c.deployments,
+	})
+}
+
+// returned app is read-only (see Offline())
+func LoadFromSnapshot(path string, logger *log.Logger) (*App, time.Time, error) {
+	snap := snapshot{}
+	if err := jsonfile.Read(path, &snap, true); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	store := &Store{
+		releases:    snap.Releases,
+		deployments: snap.Deployments,
+		logl:        logex.Levels(logger),
+	}
+
+	if store.releases == nil {
+		store.releases = []SoftwareRelease{}
+	}
+	if store.deployments == nil {
+		store.deployments = []Deployment{}
+	}
+
+	return &App{State: store}, snap.Saved, nil
+}
+
+// offline app has no connection to Event Horizon, so it can't record new events
+func (a *App) Offline() bool {
+	return a.Writer == nil
+}
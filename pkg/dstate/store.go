@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,7 +24,60 @@ type SoftwareRelease struct {
 	RevisionFriendly     string
 	RevisionId           string
 	ArtefactsLocation    string
-	DeployerSpecFilename string // for the main deployment unit (f.ex. Varasto has > 1 units)
+	DeployerSpecFilename string                              // for the main deployment unit (f.ex. Varasto has > 1 units)
+	Units                map[string]string                   // additional units => deployer spec filename
+	Checksums            map[string]ddomain.ArtefactChecksum // can be empty for older releases
+}
+
+// unit "" means the main deployment unit
+func (s SoftwareRelease) DeployerSpecFilenameForUnit(unit string) (string, error) {
+	if unit == "" {
+		if s.DeployerSpecFilename == "" { // older releases
+			return "deployerspec.zip", nil
+		}
+
+		return s.DeployerSpecFilename, nil
+	}
+
+	filename, found := s.Units[unit]
+	if !found {
+		available := []string{}
+		for availableUnit := range s.Units {
+			available = append(available, availableUnit)
+		}
+		sort.Strings(available)
+
+		return "", fmt.Errorf(
+			"release %s does not have unit '%s' (available: %s)",
+			s.Id,
+			unit,
+			strings.Join(available, ", "))
+	}
+
+	return filename, nil
+}
+
+type DeploymentStatus string
+
+const (
+	DeploymentStatusInProgress DeploymentStatus = "in-progress" // or deployer crashed before recording outcome
+	DeploymentStatusSucceeded  DeploymentStatus = "succeeded"
+	DeploymentStatusFailed     DeploymentStatus = "failed"
+	DeploymentStatusDestroyed  DeploymentStatus = "destroyed" // service's resources were torn down
+)
+
+type Deployment struct {
+	Id               string
+	ServiceId        string
+	ReleaseId        string
+	Unit             string // "" = main deployment unit
+	SoftwareUniqueId string // can be empty for older deployments
+	Rollback         bool
+	Operator         string
+	Started          time.Time
+	Status           DeploymentStatus
+	ExitCode         int
+	Duration         time.Duration
 }
 
 const (
@@ -30,17 +85,19 @@ const (
 )
 
 type Store struct {
-	version  ehclient.Cursor
-	mu       sync.Mutex
-	releases []SoftwareRelease
-	logl     *logex.Leveled
+	version     ehclient.Cursor
+	mu          sync.Mutex
+	releases    []SoftwareRelease
+	deployments []Deployment // oldest first
+	logl        *logex.Leveled
 }
 
 func New(tenant ehreader.Tenant, logger *log.Logger) *Store {
 	return &Store{
-		version:  ehclient.Beginning(tenant.Stream(Stream)),
-		releases: []SoftwareRelease{},
-		logl:     logex.Levels(logger),
+		version:     ehclient.Beginning(tenant.Stream(Stream)),
+		releases:    []SoftwareRelease{},
+		deployments: []Deployment{},
+		logl:        logex.Levels(logger),
 	}
 }
 
@@ -90,6 +147,85 @@ func (c *Store) All() []SoftwareRelease {
 	return c.releases
 }
 
+// deployment history for a service
+func (c *Store) DeploymentsNewestFirst(serviceId string) []Deployment {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deployments := []Deployment{}
+	for i := len(c.deployments) - 1; i >= 0; i-- {
+		if c.deployments[i].ServiceId == serviceId {
+			deployments = append(deployments, c.deployments[i])
+		}
+	}
+
+	return deployments
+}
+
+// answers "what is deployed for service X and since when"
+func (c *Store) CurrentDeployment(serviceId string) (*Deployment, error) {
+	for _, deployment := range c.DeploymentsNewestFirst(serviceId) { // uses locking
+		switch deployment.Status {
+		case DeploymentStatusSucceeded:
+			return &deployment, nil
+		case DeploymentStatusDestroyed:
+			return nil, fmt.Errorf("service has been destroyed: %s", serviceId)
+		}
+	}
+
+	return nil, fmt.Errorf("no successful deployment found for service: %s", serviceId)
+}
+
+// the successful deployment that preceded the current one, with a different release.
+// this is what rolling back would redeploy. a rollback undoes the deployment it rolled
+// back from, so consecutive rollbacks keep going further back instead of flipping
+// between two releases.
+func (c *Store) PreviousDeployment(serviceId string) (*Deployment, error) {
+	current, err := c.CurrentDeployment(serviceId) // uses locking
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// successful deployments, each with a different release than the one below it
+	stack := []Deployment{}
+
+	for _, deployment := range c.deployments {
+		if deployment.ServiceId != serviceId {
+			continue
+		}
+
+		switch deployment.Status {
+		case DeploymentStatusDestroyed:
+			stack = []Deployment{}
+			continue
+		case DeploymentStatusSucceeded:
+		default:
+			continue
+		}
+
+		if deployment.Rollback && len(stack) > 0 { // undo the deployment we rolled back from
+			stack = stack[:len(stack)-1]
+		}
+
+		if len(stack) > 0 && stack[len(stack)-1].ReleaseId == deployment.ReleaseId { // redeploy
+			stack[len(stack)-1] = deployment
+		} else {
+			stack = append(stack, deployment)
+		}
+	}
+
+	if len(stack) < 2 {
+		return nil, fmt.Errorf("no release to roll back to from %s for service: %s", current.ReleaseId, serviceId)
+	}
+
+	previous := stack[len(stack)-2]
+
+	return &previous, nil
+}
+
 func (c *Store) GetEventTypes() ehevent.Allocators {
 	return ddomain.Types
 }
@@ -120,6 +256,45 @@ func (c *Store) processEvent(ev ehevent.Event) error {
 			RevisionId:           e.RevisionId,
 			ArtefactsLocation:    e.ArtefactsLocation,
 			DeployerSpecFilename: e.DeployerSpecFilename,
+			Units:                e.Units,
+			Checksums:            e.Checksums,
+		})
+	case *ddomain.DeploymentStarted:
+		c.deployments = append(c.deployments, Deployment{
+			Id:               e.Id,
+			ServiceId:        e.ServiceId,
+			ReleaseId:        e.ReleaseId,
+			Unit:             e.Unit,
+			SoftwareUniqueId: e.SoftwareUniqueId,
+			Rollback:         e.Rollback,
+			Operator:         e.Meta().UserId,
+			Started:          e.Meta().Timestamp,
+			Status:           DeploymentStatusInProgress,
+		})
+	case *ddomain.DeploymentSucceeded:
+		deployment, err := c.deploymentByIdInternal(e.Id)
+		if err != nil {
+			return err
+		}
+
+		deployment.Status = DeploymentStatusSucceeded
+		deployment.Duration = e.Duration
+	case *ddomain.DeploymentFailed:
+		deployment, err := c.deploymentByIdInternal(e.Id)
+		if err != nil {
+			return err
+		}
+
+		deployment.Status = DeploymentStatusFailed
+		deployment.ExitCode = e.ExitCode
+		deployment.Duration = e.Duration
+	case *ddomain.ServiceDestroyed:
+		c.deployments = append(c.deployments, Deployment{
+			ServiceId: e.ServiceId,
+			ReleaseId: e.ReleaseId,
+			Operator:  e.Meta().UserId,
+			Started:   e.Meta().Timestamp,
+			Status:    DeploymentStatusDestroyed,
 		})
 	default:
 		return ehreader.UnsupportedEventTypeErr(ev)
@@ -128,6 +303,17 @@ func (c *Store) processEvent(ev ehevent.Event) error {
 	return nil
 }
 
+// returns pointer for mutation. caller must hold the lock
+func (c *Store) deploymentByIdInternal(deploymentId string) (*Deployment, error) {
+	for i := range c.deployments {
+		if c.deployments[i].Id == deploymentId {
+			return &c.deployments[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("Deployment not found by ID: %s", deploymentId)
+}
+
 type App struct {
 	State     *Store
 	Reader    *ehreader.Reader
@@ -2,6 +2,9 @@ package dstate
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -25,6 +28,12 @@ func TestStore(t *testing.T) {
 			"9c39d0271d0bd51c7ddfb55dc3051e68b6953c33",
 			"https://download.com/dl/",
 			"deployerspec.zip",
+			map[string]string{
+				"worker": "deployerspec-worker.zip",
+			},
+			map[string]ddomain.ArtefactChecksum{
+				"deployerspec.zip": {Sha256: "8a39d2abd3999ab73c34db2476849cddf303ce389b35826850f9a700589b4a90", Size: 3},
+			},
 			ehevent.MetaSystemUser(t0)),
 	)
 
@@ -43,4 +52,210 @@ func TestStore(t *testing.T) {
 	assert.EqualString(t, releases[0].RevisionId, "9c39d0271d0bd51c7ddfb55dc3051e68b6953c33")
 	assert.EqualString(t, releases[0].ArtefactsLocation, "https://download.com/dl/")
 	assert.EqualString(t, releases[0].DeployerSpecFilename, "deployerspec.zip")
+	assert.Assert(t, releases[0].Checksums["deployerspec.zip"].Size == 3)
+
+	specFilenameForUnit := func(unit string) string {
+		filename, err := releases[0].DeployerSpecFilenameForUnit(unit)
+		if err != nil {
+			return err.Error()
+		}
+		return filename
+	}
+
+	assert.EqualString(t, specFilenameForUnit(""), "deployerspec.zip")
+	assert.EqualString(t, specFilenameForUnit("worker"), "deployerspec-worker.zip")
+	assert.EqualString(t, specFilenameForUnit("api"), "release id1 does not have unit 'api' (available: worker)")
+}
+
+func TestDeployments(t *testing.T) {
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d1", "hq", "id1", "", "", false, ehevent.Meta(t0, "joonas")),
+		ddomain.NewDeploymentSucceeded("d1", 2*time.Minute, ehevent.Meta(t0.Add(2*time.Minute), "joonas")),
+		ddomain.NewDeploymentStarted("d2", "hq", "id2", "", "", false, ehevent.Meta(t0.Add(time.Hour), "joonas")),
+		ddomain.NewDeploymentFailed("d2", 1, time.Minute, "exit status 1", ehevent.Meta(t0.Add(time.Hour), "joonas")),
+		ddomain.NewDeploymentStarted("d3", "anotherservice", "id3", "", "", false, ehevent.Meta(t0.Add(2*time.Hour), "ci")),
+		ddomain.NewDeploymentStarted("d4", "destroyedservice", "id3", "", "", false, ehevent.Meta(t0.Add(3*time.Hour), "ci")),
+		ddomain.NewDeploymentSucceeded("d4", time.Minute, ehevent.Meta(t0.Add(3*time.Hour), "ci")),
+		ddomain.NewServiceDestroyed("destroyedservice", "id3", ehevent.Meta(t0.Add(4*time.Hour), "ci")),
+	)
+
+	app, err := LoadUntilRealtime(
+		context.Background(),
+		ehreader.NewTenantCtx(ehreader.TenantId("42"), eventLog),
+		nil)
+	assert.Ok(t, err)
+
+	hqDeployments := app.State.DeploymentsNewestFirst("hq")
+
+	assert.Assert(t, len(hqDeployments) == 2)
+	assert.EqualString(t, hqDeployments[0].Id, "d2")
+	assert.EqualString(t, string(hqDeployments[0].Status), "failed")
+	assert.Assert(t, hqDeployments[0].ExitCode == 1)
+	assert.EqualString(t, hqDeployments[1].Id, "d1")
+	assert.EqualString(t, hqDeployments[1].Operator, "joonas")
+
+	current, err := app.State.CurrentDeployment("hq")
+	assert.Ok(t, err)
+	assert.EqualString(t, current.ReleaseId, "id1")
+	assert.Assert(t, current.Started.Equal(t0))
+	assert.Assert(t, current.Duration == 2*time.Minute)
+
+	_, err = app.State.CurrentDeployment("anotherservice")
+	assert.EqualString(t, err.Error(), "no successful deployment found for service: anotherservice")
+
+	_, err = app.State.CurrentDeployment("destroyedservice")
+	assert.EqualString(t, err.Error(), "service has been destroyed: destroyedservice")
+}
+
+func TestPreviousDeployment(t *testing.T) {
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	meta := func(minutes int) ehevent.EventMeta {
+		return ehevent.Meta(t0.Add(time.Duration(minutes)*time.Minute), "joonas")
+	}
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d1", "hq", "id1", "", "sw1", false, meta(0)),
+		ddomain.NewDeploymentSucceeded("d1", time.Minute, meta(1)),
+		ddomain.NewDeploymentStarted("d2", "hq", "id2", "", "sw1", false, meta(2)),
+		ddomain.NewDeploymentFailed("d2", 1, time.Minute, "exit status 1", meta(3)),
+		ddomain.NewDeploymentStarted("d3", "hq", "id3", "", "sw1", false, meta(4)),
+		ddomain.NewDeploymentSucceeded("d3", time.Minute, meta(5)),
+		ddomain.NewDeploymentStarted("d4", "hq", "id3", "", "sw1", false, meta(6)), // redeploy of same
+		ddomain.NewDeploymentSucceeded("d4", time.Minute, meta(7)),
+		ddomain.NewDeploymentStarted("d5", "anotherservice", "id1", "", "sw1", false, meta(8)),
+		ddomain.NewDeploymentSucceeded("d5", time.Minute, meta(9)),
+	)
+
+	app, err := LoadUntilRealtime(
+		context.Background(),
+		ehreader.NewTenantCtx(ehreader.TenantId("42"), eventLog),
+		nil)
+	assert.Ok(t, err)
+
+	previous, err := app.State.PreviousDeployment("hq")
+	assert.Ok(t, err)
+	assert.EqualString(t, previous.Id, "d1")
+	assert.EqualString(t, previous.ReleaseId, "id1")
+
+	_, err = app.State.PreviousDeployment("anotherservice")
+	assert.EqualString(t, err.Error(), "no release to roll back to from id1 for service: anotherservice")
+
+	_, err = app.State.PreviousDeployment("nonexistent")
+	assert.EqualString(t, err.Error(), "no successful deployment found for service: nonexistent")
+}
+
+func TestConsecutiveRollbacks(t *testing.T) {
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	meta := func(minutes int) ehevent.EventMeta {
+		return ehevent.Meta(t0.Add(time.Duration(minutes)*time.Minute), "joonas")
+	}
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d1", "hq", "id1", "", "sw1", false, meta(0)),
+		ddomain.NewDeploymentSucceeded("d1", time.Minute, meta(1)),
+		ddomain.NewDeploymentStarted("d2", "hq", "id2", "", "sw1", false, meta(2)),
+		ddomain.NewDeploymentSucceeded("d2", time.Minute, meta(3)),
+		ddomain.NewDeploymentStarted("d3", "hq", "id3", "", "sw1", false, meta(4)),
+		ddomain.NewDeploymentSucceeded("d3", time.Minute, meta(5)),
+	)
+
+	tenantCtx := ehreader.NewTenantCtx(ehreader.TenantId("42"), eventLog)
+
+	previousReleaseId := func() string {
+		app, err := LoadUntilRealtime(context.Background(), tenantCtx, nil)
+		assert.Ok(t, err)
+
+		previous, err := app.State.PreviousDeployment("hq")
+		if err != nil {
+			return err.Error()
+		}
+
+		return previous.ReleaseId
+	}
+
+	assert.EqualString(t, previousReleaseId(), "id2")
+
+	// first rollback: id3 -> id2
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d4", "hq", "id2", "", "sw1", true, meta(6)),
+		ddomain.NewDeploymentSucceeded("d4", time.Minute, meta(7)))
+
+	// must not flip back to id3
+	assert.EqualString(t, previousReleaseId(), "id1")
+
+	// second rollback: id2 -> id1
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d5", "hq", "id1", "", "sw1", true, meta(8)),
+		ddomain.NewDeploymentSucceeded("d5", time.Minute, meta(9)))
+
+	assert.EqualString(t, previousReleaseId(), "no release to roll back to from id1 for service: hq")
+
+	// new deploy after rollbacks can again be rolled back from
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewDeploymentStarted("d6", "hq", "id4", "", "sw1", false, meta(10)),
+		ddomain.NewDeploymentSucceeded("d6", time.Minute, meta(11)))
+
+	assert.EqualString(t, previousReleaseId(), "id1")
+}
+
+func TestSnapshot(t *testing.T) {
+	t0 := time.Date(2020, 2, 20, 14, 2, 0, 0, time.UTC)
+
+	eventLog := ehreadertest.NewEventLog()
+	eventLog.AppendE(
+		"/t-42/software-releases",
+		ddomain.NewReleaseCreated(
+			"id1",
+			"function61/coolproduct",
+			"20200219_1609_9c39d027",
+			"9c39d0271d0bd51c7ddfb55dc3051e68b6953c33",
+			"https://download.com/dl/",
+			"deployerspec.zip",
+			nil,
+			nil,
+			ehevent.MetaSystemUser(t0)),
+		ddomain.NewDeploymentStarted("d1", "hq", "id1", "", "", false, ehevent.Meta(t0, "joonas")),
+		ddomain.NewDeploymentSucceeded("d1", 2*time.Minute, ehevent.Meta(t0.Add(2*time.Minute), "joonas")),
+	)
+
+	app, err := LoadUntilRealtime(
+		context.Background(),
+		ehreader.NewTenantCtx(ehreader.TenantId("42"), eventLog),
+		nil)
+	assert.Ok(t, err)
+	assert.Assert(t, !app.Offline())
+
+	dir, err := ioutil.TempDir("", "dstate-test-")
+	assert.Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	snapshotPath := filepath.Join(dir, "snapshot.json")
+
+	assert.Ok(t, app.State.SaveSnapshot(snapshotPath))
+
+	offlineApp, _, err := LoadFromSnapshot(snapshotPath, nil)
+	assert.Ok(t, err)
+	assert.Assert(t, offlineApp.Offline())
+
+	release, err := offlineApp.State.ById("id1")
+	assert.Ok(t, err)
+	assert.EqualString(t, release.ArtefactsLocation, "https://download.com/dl/")
+
+	current, err := offlineApp.State.CurrentDeployment("hq")
+	assert.Ok(t, err)
+	assert.EqualString(t, current.ReleaseId, "id1")
+	assert.Assert(t, current.Duration == 2*time.Minute)
 }
@@ -5,6 +5,8 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strings"
+	"sync"
 
 	"github.com/function61/gokit/ezhttp"
 )
@@ -29,18 +31,38 @@ type Asset struct {
 }
 
 type Client struct {
-	personalAccessToken string
+	endpoint  string
+	tokenFn   accessTokenObtainer
+	tokenOnce sync.Once
+	token     string
+	tokenErr  error
 }
 
 type accessTokenObtainer func() (string, error)
 
 func New(tokenFn accessTokenObtainer) (*Client, error) {
-	personalAccessToken, err := tokenFn()
-	if err != nil {
-		return nil, err
+	return NewWithEndpoint(DefaultEndpoint, tokenFn)
+}
+
+// for GitHub Enterprise ("https://github.example.com/api/v3").
+// tokenFn is called lazily on first request, so merely constructing a client doesn't
+// require credentials.
+func NewWithEndpoint(endpoint string, tokenFn accessTokenObtainer) (*Client, error) {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		tokenFn:  tokenFn,
+	}, nil
+}
+
+func (g *Client) authorization() (ezhttp.ConfigPiece, error) {
+	g.tokenOnce.Do(func() {
+		g.token, g.tokenErr = g.tokenFn()
+	})
+	if g.tokenErr != nil {
+		return ezhttp.ConfigPiece{}, g.tokenErr
 	}
 
-	return &Client{personalAccessToken}, nil
+	return ezhttp.Header("Authorization", "token "+g.token), nil
 }
 
 func (g *Client) ListAssetsForRelease(ctx context.Context, repo RepoRef, releaseId string) ([]Asset, error) {
@@ -48,7 +70,7 @@ func (g *Client) ListAssetsForRelease(ctx context.Context, repo RepoRef, release
 	// NOTE: there's also "release by tag" endpoint available
 	endpoint := fmt.Sprintf(
 		"%s/repos/%s/%s/releases/%s",
-		DefaultEndpoint,
+		g.endpoint,
 		repo.Owner,
 		repo.Name,
 		releaseId)
@@ -57,10 +79,15 @@ func (g *Client) ListAssetsForRelease(ctx context.Context, repo RepoRef, release
 		Assets []Asset `json:"assets"`
 	}{}
 
+	authorization, err := g.authorization()
+	if err != nil {
+		return nil, err
+	}
+
 	if _, err := ezhttp.Get(
 		ctx,
 		endpoint,
-		ezhttp.Header("Authorization", "token "+g.personalAccessToken),
+		authorization,
 		ezhttp.RespondsJson(&resp, true),
 	); err != nil {
 		return nil, err
@@ -70,11 +97,16 @@ func (g *Client) ListAssetsForRelease(ctx context.Context, repo RepoRef, release
 }
 
 func (g *Client) DownloadAsset(ctx context.Context, asset Asset) (io.ReadCloser, error) {
+	authorization, err := g.authorization()
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := ezhttp.Get(
 		ctx,
 		asset.Url,
 		ezhttp.Header("Accept", "application/octet-stream"),
-		ezhttp.Header("Authorization", "token "+g.personalAccessToken),
+		authorization,
 	)
 	if err != nil {
 		return nil, err
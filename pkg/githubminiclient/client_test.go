@@ -0,0 +1,47 @@
+package githubminiclient
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestListAndDownloadAssets(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.EqualString(t, r.Header.Get("Authorization"), "token secret")
+
+		switch r.URL.Path {
+		case "/api/v3/repos/function61/coolproduct/releases/123":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"assets": [{"id": 1, "name": "deployerspec.zip", "url": "%s/api/v3/assets/1"}]}`, server.URL)
+		case "/api/v3/assets/1":
+			assert.EqualString(t, r.Header.Get("Accept"), "application/octet-stream")
+			fmt.Fprint(w, "spec content")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	gmc, err := NewWithEndpoint(server.URL+"/api/v3/", AccessToken("secret"))
+	assert.Ok(t, err)
+
+	assets, err := gmc.ListAssetsForRelease(context.TODO(), NewRepoRef("function61", "coolproduct"), "123")
+	assert.Ok(t, err)
+	assert.Assert(t, len(assets) == 1)
+	assert.EqualString(t, assets[0].Name, "deployerspec.zip")
+
+	content, err := gmc.DownloadAsset(context.TODO(), assets[0])
+	assert.Ok(t, err)
+	defer content.Close()
+
+	contentBytes, err := ioutil.ReadAll(content)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(contentBytes), "spec content")
+}
@@ -0,0 +1,388 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/function61/gokit/hashverifyreader"
+)
+
+const (
+	MediaTypeImageManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// returns empty username if no credentials for the registry
+type CredentialsFunc func(registry string) (username string, password string, err error)
+
+// OCI distribution API client - mainly to resolve manifests and download blobs
+type Client struct {
+	credentials CredentialsFunc
+	httpClient  *http.Client
+	tokensMu    sync.Mutex
+	tokens      map[string]string // bearer tokens keyed by "<registry>/<repository>"
+}
+
+func New(credentials CredentialsFunc) *Client {
+	return &Client{
+		credentials: credentials,
+		httpClient:  http.DefaultClient,
+		tokens:      map[string]string{},
+	}
+}
+
+// returns manifest and its digest
+func (c *Client) ResolveManifest(ctx context.Context, ref Reference) (*Manifest, string, error) {
+	withErr := func(err error) (*Manifest, string, error) {
+		return nil, "", fmt.Errorf("ResolveManifest %s: %w", ref.String(), err)
+	}
+
+	res, err := c.get(ctx, ref, "/manifests/"+ref.TagOrDigest(), MediaTypeImageManifest+", "+MediaTypeDockerManifest)
+	if err != nil {
+		return withErr(err)
+	}
+	defer res.Body.Close()
+
+	manifestJson, err := ioutil.ReadAll(io.LimitReader(res.Body, 4*1024*1024))
+	if err != nil {
+		return withErr(err)
+	}
+
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(manifestJson))
+
+	if ref.Digest != "" && ref.Digest != digest {
+		return withErr(fmt.Errorf("digest mismatch: expected %s, got %s", ref.Digest, digest))
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(manifestJson, manifest); err != nil {
+		return withErr(err)
+	}
+
+	return manifest, digest, nil
+}
+
+// streams a blob. the stream errors at EOF if digest or size doesn't match.
+func (c *Client) FetchBlob(ctx context.Context, ref Reference, layer Layer) (io.ReadCloser, error) {
+	withErr := func(err error) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("FetchBlob %s: %w", layer.Digest, err)
+	}
+
+	if !strings.HasPrefix(layer.Digest, "sha256:") {
+		return withErr(errors.New("unsupported digest algorithm"))
+	}
+
+	expectedHash, err := hex.DecodeString(layer.Digest[len("sha256:"):])
+	if err != nil {
+		return withErr(err)
+	}
+
+	res, err := c.get(ctx, ref, "/blobs/"+layer.Digest, "")
+	if err != nil {
+		return withErr(err)
+	}
+
+	return &readCloser{
+		hashverifyreader.New(
+			&exactSizeReader{io.LimitReader(res.Body, int64(layer.Size)+1), int64(layer.Size), 0},
+			sha256.New(),
+			expectedHash),
+		res.Body,
+	}, nil
+}
+
+// GETs path under the repository, doing authentication dance if registry requires it
+func (c *Client) get(ctx context.Context, ref Reference, path string, accept string) (*http.Response, error) {
+	endpoint := registryBaseUrl(ref.Registry) + "/v2/" + ref.Repository + path
+
+	tokenKey := ref.Registry + "/" + ref.Repository
+
+	do := func(authorization string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		return c.httpClient.Do(req)
+	}
+
+	c.tokensMu.Lock()
+	authorization := c.tokens[tokenKey]
+	c.tokensMu.Unlock()
+
+	res, err := do(authorization)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge := res.Header.Get("WWW-Authenticate")
+		res.Body.Close()
+
+		authorization, err := c.authenticate(ctx, ref, challenge)
+		if err != nil {
+			return nil, err
+		}
+
+		c.tokensMu.Lock()
+		c.tokens[tokenKey] = authorization
+		c.tokensMu.Unlock()
+
+		res, err = do(authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s", endpoint, res.Status)
+	}
+
+	return res, nil
+}
+
+// responds to registry's WWW-Authenticate challenge. returns value for Authorization header.
+func (c *Client) authenticate(ctx context.Context, ref Reference, challenge string) (string, error) {
+	username, password, err := c.credentials(ref.Registry)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if username == "" {
+			return "", fmt.Errorf("registry %s requires credentials", ref.Registry)
+		}
+
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+	case "bearer":
+		tokenUrl, err := url.Parse(params["realm"])
+		if err != nil {
+			return "", err
+		}
+
+		query := tokenUrl.Query()
+		if service := params["service"]; service != "" {
+			query.Set("service", service)
+		}
+		if scope := params["scope"]; scope != "" {
+			query.Set("scope", scope)
+		} else {
+			query.Set("scope", "repository:"+ref.Repository+":pull")
+		}
+		tokenUrl.RawQuery = query.Encode()
+
+		req, err := http.NewRequest(http.MethodGet, tokenUrl.String(), nil)
+		if err != nil {
+			return "", err
+		}
+		req = req.WithContext(ctx)
+
+		if username != "" {
+			req.SetBasicAuth(username, password)
+		}
+
+		res, err := c.httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("token endpoint %s: unexpected status %s", params["realm"], res.Status)
+		}
+
+		tokenRes := struct {
+			Token       string `json:"token"`
+			AccessToken string `json:"access_token"`
+		}{}
+		if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+			return "", err
+		}
+
+		token := tokenRes.Token
+		if token == "" {
+			token = tokenRes.AccessToken
+		}
+
+		return "Bearer " + token, nil
+	default:
+		return "", fmt.Errorf("unsupported authentication challenge: %s", challenge)
+	}
+}
+
+// credentials from Docker's config.json ("$ docker login" writes there). credential
+// helpers are not supported.
+func DockerConfigCredentials() CredentialsFunc {
+	return func(registry string) (string, string, error) {
+		configDir := os.Getenv("DOCKER_CONFIG")
+		if configDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", "", err
+			}
+
+			configDir = filepath.Join(home, ".docker")
+		}
+
+		configJson, err := ioutil.ReadFile(filepath.Join(configDir, "config.json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "", "", nil
+			}
+
+			return "", "", err
+		}
+
+		config := struct {
+			Auths map[string]struct {
+				Auth string `json:"auth"`
+			} `json:"auths"`
+		}{}
+		if err := json.Unmarshal(configJson, &config); err != nil {
+			return "", "", fmt.Errorf("DockerConfigCredentials: %w", err)
+		}
+
+		candidates := []string{registry, "https://" + registry}
+		if registry == dockerHubRegistry {
+			candidates = append(candidates, "https://index.docker.io/v1/")
+		}
+
+		for _, candidate := range candidates {
+			entry, found := config.Auths[candidate]
+			if !found || entry.Auth == "" {
+				continue
+			}
+
+			userPass, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("DockerConfigCredentials: %s: %w", candidate, err)
+			}
+
+			parts := strings.SplitN(string(userPass), ":", 2)
+			if len(parts) != 2 {
+				return "", "", fmt.Errorf("DockerConfigCredentials: %s: invalid auth", candidate)
+			}
+
+			return parts[0], parts[1], nil
+		}
+
+		return "", "", nil
+	}
+}
+
+func AnonymousCredentials() CredentialsFunc {
+	return func(_ string) (string, string, error) {
+		return "", "", nil
+	}
+}
+
+func registryBaseUrl(registry string) string {
+	if registry == dockerHubRegistry {
+		return "https://" + dockerHubRegistryEndpoint
+	}
+
+	// same convention as Docker: local registries are usually not behind TLS
+	if strings.HasPrefix(registry, "localhost:") || strings.HasPrefix(registry, "127.0.0.1:") || registry == "localhost" {
+		return "http://" + registry
+	}
+
+	return "https://" + registry
+}
+
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+// => "Bearer", {realm: "https://...", service: "registry.docker.io"}
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := map[string]string{}
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) != 2 {
+		return parts[0], params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq == -1 {
+			break
+		}
+
+		key := strings.TrimSpace(rest[:eq])
+		rest = rest[eq+1:]
+
+		value := ""
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end == -1 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end == -1 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end:]
+			}
+		}
+
+		params[strings.ToLower(key)] = value
+
+		rest = strings.TrimPrefix(strings.TrimSpace(rest), ",")
+	}
+
+	return parts[0], params
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// errors at EOF if stream was of unexpected size
+type exactSizeReader struct {
+	source   io.Reader
+	expected int64
+	read     int64
+}
+
+func (e *exactSizeReader) Read(p []byte) (int, error) {
+	n, err := e.source.Read(p)
+	e.read += int64(n)
+
+	if e.read > e.expected {
+		return n, fmt.Errorf("size mismatch: expected %d bytes, got more", e.expected)
+	}
+
+	if err == io.EOF && e.read != e.expected {
+		return n, fmt.Errorf("size mismatch: expected %d bytes, got %d", e.expected, e.read)
+	}
+
+	return n, err
+}
@@ -0,0 +1,195 @@
+package oci
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestParseReference(t *testing.T) {
+	tcs := []struct {
+		input  string
+		output string
+	}{
+		{"redis", "docker.io/library/redis:latest"},
+		{"redis:5", "docker.io/library/redis:5"},
+		{"fn61/deployer:20200101", "docker.io/fn61/deployer:20200101"},
+		{"localhost:5000/img:tag", "localhost:5000/img:tag"},
+		{"localhost:5000/img", "localhost:5000/img:latest"},
+		{"localhost/img:tag", "localhost/img:tag"},
+		{"ghcr.io/org/sub/img:v1", "ghcr.io/org/sub/img:v1"},
+		{"ghcr.io/org/img@sha256:abcd", "ghcr.io/org/img@sha256:abcd"},
+		{"ghcr.io/org/img@md5:abcd", "ERROR: ParseReference: unsupported digest: md5:abcd"},
+		{"", "ERROR: ParseReference: empty reference"},
+	}
+
+	for _, tc := range tcs {
+		tc := tc // pin
+		t.Run(tc.input, func(t *testing.T) {
+			ref, err := ParseReference(tc.input)
+			output := ""
+			if err != nil {
+				output = "ERROR: " + err.Error()
+			} else {
+				output = ref.String()
+			}
+
+			assert.EqualString(t, output, tc.output)
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/redis:pull"`)
+
+	assert.EqualString(t, scheme, "Bearer")
+	assert.EqualString(t, params["realm"], "https://auth.docker.io/token")
+	assert.EqualString(t, params["service"], "registry.docker.io")
+	assert.EqualString(t, params["scope"], "repository:library/redis:pull")
+}
+
+func TestResolveAndFetch(t *testing.T) {
+	registry := newFakeRegistry(t, "hello world")
+	defer registry.Close()
+
+	ctx := context.Background()
+
+	ref, err := ParseReference(registry.Listener.Addr().String() + "/org/artefacts:v1")
+	assert.Ok(t, err)
+
+	client := New(func(registry string) (string, string, error) {
+		return "joonas", "hunter2", nil
+	})
+
+	manifest, _, err := client.ResolveManifest(ctx, *ref)
+	assert.Ok(t, err)
+	assert.Assert(t, len(manifest.Layers) == 1)
+	assert.EqualString(t, manifest.Layers[0].Annotations["org.opencontainers.image.title"], "hello.txt")
+
+	blob, err := client.FetchBlob(ctx, *ref, manifest.Layers[0])
+	assert.Ok(t, err)
+	defer blob.Close()
+
+	content, err := ioutil.ReadAll(blob)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(content), "hello world")
+}
+
+func TestFetchBlobDigestMismatch(t *testing.T) {
+	registry := newFakeRegistry(t, "hello world")
+	defer registry.Close()
+
+	ctx := context.Background()
+
+	ref, err := ParseReference(registry.Listener.Addr().String() + "/org/artefacts:v1")
+	assert.Ok(t, err)
+
+	client := New(func(registry string) (string, string, error) {
+		return "joonas", "hunter2", nil
+	})
+
+	manifest, _, err := client.ResolveManifest(ctx, *ref)
+	assert.Ok(t, err)
+
+	registry.tamperBlob("hello w0rld")
+
+	blob, err := client.FetchBlob(ctx, *ref, manifest.Layers[0])
+	assert.Ok(t, err)
+	defer blob.Close()
+
+	_, err = ioutil.ReadAll(blob)
+	assert.EqualString(t, err.Error(), "hashVerifyReader: digest mismatch")
+}
+
+func TestUnauthorized(t *testing.T) {
+	registry := newFakeRegistry(t, "hello world")
+	defer registry.Close()
+
+	ref, err := ParseReference(registry.Listener.Addr().String() + "/org/artefacts:v1")
+	assert.Ok(t, err)
+
+	_, _, err = New(AnonymousCredentials()).ResolveManifest(context.Background(), *ref)
+	assert.Assert(t, strings.Contains(err.Error(), "token endpoint"))
+}
+
+type fakeRegistry struct {
+	*httptest.Server
+	blob string
+}
+
+func (f *fakeRegistry) tamperBlob(content string) {
+	f.blob = content
+}
+
+// in-process registry that requires bearer token auth
+func newFakeRegistry(t *testing.T, blobContent string) *fakeRegistry {
+	t.Helper()
+
+	registry := &fakeRegistry{blob: blobContent}
+
+	blobDigest := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(blobContent)))
+
+	manifestJson, err := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeImageManifest,
+		Layers: []Layer{
+			{
+				MediaType: "application/octet-stream",
+				Digest:    blobDigest,
+				Size:      len(blobContent),
+				Annotations: map[string]string{
+					"org.opencontainers.image.title": "hello.txt",
+				},
+			},
+		},
+	})
+	assert.Ok(t, err)
+
+	const token = "t0ken"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "joonas" || pass != "hunter2" {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Query().Get("scope") != "repository:org/artefacts:pull" {
+			http.Error(w, "bad scope", http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprintf(w, `{"token": "%s"}`, token)
+	})
+	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="fake",scope="repository:org/artefacts:pull"`,
+				registry.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/org/artefacts/manifests/v1":
+			w.Header().Set("Content-Type", MediaTypeImageManifest)
+			_, _ = w.Write(manifestJson)
+		case "/v2/org/artefacts/blobs/" + blobDigest:
+			_, _ = w.Write([]byte(registry.blob))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	registry.Server = httptest.NewServer(mux)
+
+	return registry
+}
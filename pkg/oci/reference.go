@@ -0,0 +1,93 @@
+package oci
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	dockerHubRegistry         = "docker.io"
+	dockerHubRegistryEndpoint = "registry-1.docker.io"
+)
+
+// "localhost:5000/img:tag" => {Registry: "localhost:5000", Repository: "img", Tag: "tag"}
+type Reference struct {
+	Registry   string // "docker.io", "ghcr.io", "localhost:5000"
+	Repository string // "library/redis", "function61/deployer"
+	Tag        string // empty if Digest given
+	Digest     string // "sha256:..." (optional)
+}
+
+// parses image refs with the same normalization rules as Docker:
+//
+//	"redis" => "docker.io/library/redis:latest"
+//	"localhost:5000/img:tag" => registry "localhost:5000"
+//	"ghcr.io/org/img@sha256:..." => by digest
+func ParseReference(ref string) (*Reference, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("ParseReference: empty reference")
+	}
+
+	parsed := &Reference{}
+
+	remainder := ref
+
+	if idx := strings.Index(remainder, "@"); idx != -1 {
+		parsed.Digest = remainder[idx+1:]
+		remainder = remainder[:idx]
+
+		if !strings.HasPrefix(parsed.Digest, "sha256:") {
+			return nil, fmt.Errorf("ParseReference: unsupported digest: %s", parsed.Digest)
+		}
+	}
+
+	// tag separator is the last colon, if it's after the last slash ("localhost:5000/img")
+	if idx := strings.LastIndex(remainder, ":"); idx != -1 && idx > strings.LastIndex(remainder, "/") {
+		parsed.Tag = remainder[idx+1:]
+		remainder = remainder[:idx]
+	}
+
+	if parsed.Tag == "" && parsed.Digest == "" {
+		parsed.Tag = "latest"
+	}
+
+	// first component is a registry only if it looks like a hostname
+	if idx := strings.Index(remainder, "/"); idx != -1 && looksLikeRegistry(remainder[:idx]) {
+		parsed.Registry = remainder[:idx]
+		parsed.Repository = remainder[idx+1:]
+	} else {
+		parsed.Registry = dockerHubRegistry
+		parsed.Repository = remainder
+	}
+
+	if parsed.Registry == dockerHubRegistry && !strings.Contains(parsed.Repository, "/") {
+		parsed.Repository = "library/" + parsed.Repository
+	}
+
+	if parsed.Repository == "" {
+		return nil, fmt.Errorf("ParseReference: empty repository in %s", ref)
+	}
+
+	return parsed, nil
+}
+
+// tag or digest, whichever is more specific
+func (r Reference) TagOrDigest() string {
+	if r.Digest != "" {
+		return r.Digest
+	}
+
+	return r.Tag
+}
+
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Registry + "/" + r.Repository + "@" + r.Digest
+	}
+
+	return r.Registry + "/" + r.Repository + ":" + r.Tag
+}
+
+func looksLikeRegistry(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
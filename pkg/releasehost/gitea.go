@@ -0,0 +1,212 @@
+package releasehost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/function61/gokit/ezhttp"
+)
+
+type gitea struct {
+	baseUrl string // "https://git.example.com"
+	token   string // can be empty for public repos
+}
+
+func Gitea(baseUrl string, token string) ReleaseCreator {
+	return &gitea{strings.TrimSuffix(baseUrl, "/"), token}
+}
+
+type giteaRelease struct {
+	Id      int64  `json:"id"`
+	Name    string `json:"name"`
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name               string `json:"name"`
+		BrowserDownloadUrl string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (g *gitea) ListAssets(ctx context.Context, repo RepoRef, releaseId string) ([]Asset, error) {
+	release := giteaRelease{}
+
+	if _, err := ezhttp.Get(
+		ctx,
+		g.repoEndpoint(repo, "/releases/"+url.PathEscape(releaseId)),
+		g.auth(),
+		ezhttp.RespondsJson(&release, true),
+	); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseId)
+		}
+		return nil, err
+	}
+
+	assets := []Asset{}
+	for _, asset := range release.Assets {
+		assets = append(assets, Asset{
+			Name: asset.Name,
+			Url:  asset.BrowserDownloadUrl,
+		})
+	}
+
+	return assets, nil
+}
+
+func (g *gitea) DownloadAsset(ctx context.Context, asset Asset) (io.ReadCloser, error) {
+	res, err := ezhttp.Get(ctx, asset.Url, g.auth())
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+func (g *gitea) CreateRelease(
+	ctx context.Context,
+	repo RepoRef,
+	releaseName string,
+	revisionId string,
+) (string, error) {
+	existing, err := g.findRelease(ctx, repo, releaseName)
+	if err != nil {
+		return "", err
+	}
+
+	if existing != nil {
+		return strconv.FormatInt(existing.Id, 10), nil
+	}
+
+	created := giteaRelease{}
+
+	if _, err := ezhttp.Post(
+		ctx,
+		g.repoEndpoint(repo, "/releases"),
+		g.auth(),
+		ezhttp.SendJson(map[string]interface{}{
+			"name":             releaseName,
+			"tag_name":         releaseName,
+			"target_commitish": revisionId,
+			"draft":            true,
+		}),
+		ezhttp.RespondsJson(&created, true),
+	); err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(created.Id, 10), nil
+}
+
+// finds release by name or tag (or nil if not found). looks through all pages, and can't
+// use "/releases/tags/<name>" since draft releases don't have tags yet.
+func (g *gitea) findRelease(ctx context.Context, repo RepoRef, releaseName string) (*giteaRelease, error) {
+	const perPage = 50
+
+	for page := 1; ; page++ {
+		releases := []giteaRelease{}
+
+		if _, err := ezhttp.Get(
+			ctx,
+			g.repoEndpoint(repo, fmt.Sprintf("/releases?page=%d&limit=%d", page, perPage)),
+			g.auth(),
+			ezhttp.RespondsJson(&releases, true),
+		); err != nil {
+			return nil, err
+		}
+
+		for _, release := range releases {
+			if release.Name == releaseName || release.TagName == releaseName {
+				release := release
+				return &release, nil
+			}
+		}
+
+		// server can cap the limit below ours, so only an empty page tells for sure
+		if len(releases) == 0 {
+			return nil, nil
+		}
+	}
+}
+
+func (g *gitea) PublishRelease(ctx context.Context, repo RepoRef, releaseId string) error {
+	_, err := ezhttp.Post(
+		ctx,
+		g.repoEndpoint(repo, "/releases/"+url.PathEscape(releaseId)),
+		g.auth(),
+		ezhttp.SendJson(map[string]interface{}{
+			"draft": false,
+		}),
+		methodPatch())
+	return err
+}
+
+func (g *gitea) UploadAsset(
+	ctx context.Context,
+	repo RepoRef,
+	releaseId string,
+	filename string,
+	content io.Reader,
+) error {
+	body, contentType := multipartFileBody("attachment", filename, content)
+	defer body.Close()
+
+	_, err := ezhttp.Post(
+		ctx,
+		g.repoEndpoint(repo, fmt.Sprintf("/releases/%s/assets?name=%s", url.PathEscape(releaseId), url.QueryEscape(filename))),
+		g.auth(),
+		ezhttp.SendBody(body, contentType))
+	return err
+}
+
+func (g *gitea) repoEndpoint(repo RepoRef, path string) string {
+	return fmt.Sprintf(
+		"%s/api/v1/repos/%s/%s%s",
+		g.baseUrl,
+		url.PathEscape(repo.Owner),
+		url.PathEscape(repo.Name),
+		path)
+}
+
+func (g *gitea) auth() ezhttp.ConfigPiece {
+	if g.token == "" {
+		return authHeader("Authorization", "")
+	}
+
+	return authHeader("Authorization", "token "+g.token)
+}
+
+// ezhttp doesn't have Patch()
+func methodPatch() ezhttp.ConfigPiece {
+	return ezhttp.After(func(conf *ezhttp.Config) {
+		conf.Request.Method = http.MethodPatch
+	})
+}
+
+// streams content as multipart form file, so large assets don't have to fit in memory
+func multipartFileBody(fieldName string, filename string, content io.Reader) (io.ReadCloser, string) {
+	bodyReader, bodyWriter := io.Pipe()
+
+	form := multipart.NewWriter(bodyWriter)
+
+	go func() {
+		bodyWriter.CloseWithError(func() error {
+			part, err := form.CreateFormFile(fieldName, filename)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(part, content); err != nil {
+				return err
+			}
+
+			return form.Close()
+		}())
+	}()
+
+	return bodyReader, form.FormDataContentType()
+}
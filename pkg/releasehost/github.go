@@ -0,0 +1,41 @@
+package releasehost
+
+import (
+	"context"
+	"io"
+
+	"github.com/function61/deployer/pkg/githubminiclient"
+)
+
+type github struct {
+	gmc *githubminiclient.Client
+}
+
+// GitHub support is read-only, because releases are created with the full GitHub client
+func GitHub(gmc *githubminiclient.Client) Host {
+	return &github{gmc}
+}
+
+func (g *github) ListAssets(ctx context.Context, repo RepoRef, releaseId string) ([]Asset, error) {
+	ghAssets, err := g.gmc.ListAssetsForRelease(ctx, githubminiclient.NewRepoRef(repo.Owner, repo.Name), releaseId)
+	if err != nil {
+		return nil, err
+	}
+
+	assets := []Asset{}
+	for _, ghAsset := range ghAssets {
+		assets = append(assets, Asset{
+			Name: ghAsset.Name,
+			Url:  ghAsset.Url,
+		})
+	}
+
+	return assets, nil
+}
+
+func (g *github) DownloadAsset(ctx context.Context, asset Asset) (io.ReadCloser, error) {
+	return g.gmc.DownloadAsset(ctx, githubminiclient.Asset{
+		Name: asset.Name,
+		Url:  asset.Url,
+	})
+}
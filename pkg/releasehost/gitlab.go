@@ -0,0 +1,165 @@
+package releasehost
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/function61/gokit/ezhttp"
+)
+
+// GitLab releases are identified by their tag name, so release ID is the tag name
+type gitlab struct {
+	baseUrl string // "https://gitlab.com"
+	token   string // can be empty for public repos
+}
+
+func GitLab(baseUrl string, token string) ReleaseCreator {
+	return &gitlab{strings.TrimSuffix(baseUrl, "/"), token}
+}
+
+type gitlabRelease struct {
+	TagName string `json:"tag_name"`
+	Assets  struct {
+		Links []struct {
+			Name string `json:"name"`
+			Url  string `json:"url"`
+		} `json:"links"`
+	} `json:"assets"`
+}
+
+func (g *gitlab) ListAssets(ctx context.Context, repo RepoRef, releaseId string) ([]Asset, error) {
+	release := gitlabRelease{}
+
+	if _, err := ezhttp.Get(
+		ctx,
+		g.projectEndpoint(repo, "/releases/"+url.PathEscape(releaseId)),
+		g.auth(),
+		ezhttp.RespondsJson(&release, true),
+	); err != nil {
+		if isNotFound(err) {
+			return nil, fmt.Errorf("%w: %s", ErrReleaseNotFound, releaseId)
+		}
+		return nil, err
+	}
+
+	// only asset links (uploaded by us). GitLab also lists auto-generated source archives
+	// as "sources", but they're not build artefacts.
+	assets := []Asset{}
+	for _, link := range release.Assets.Links {
+		assets = append(assets, Asset{
+			Name: link.Name,
+			Url:  link.Url,
+		})
+	}
+
+	return assets, nil
+}
+
+func (g *gitlab) DownloadAsset(ctx context.Context, asset Asset) (io.ReadCloser, error) {
+	res, err := ezhttp.Get(ctx, asset.Url, g.auth())
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Body, nil
+}
+
+func (g *gitlab) CreateRelease(
+	ctx context.Context,
+	repo RepoRef,
+	releaseName string,
+	revisionId string,
+) (string, error) {
+	_, err := ezhttp.Get(
+		ctx,
+		g.projectEndpoint(repo, "/releases/"+url.PathEscape(releaseName)),
+		g.auth())
+	switch {
+	case err == nil:
+		return releaseName, nil
+	case !isNotFound(err):
+		return "", err
+	}
+
+	created := gitlabRelease{}
+
+	if _, err := ezhttp.Post(
+		ctx,
+		g.projectEndpoint(repo, "/releases"),
+		g.auth(),
+		ezhttp.SendJson(map[string]interface{}{
+			"name":     releaseName,
+			"tag_name": releaseName,
+			"ref":      revisionId, // tag is created from this if it doesn't exist
+		}),
+		ezhttp.RespondsJson(&created, true),
+	); err != nil {
+		return "", err
+	}
+
+	return created.TagName, nil
+}
+
+// GitLab releases don't have drafts
+func (g *gitlab) PublishRelease(ctx context.Context, repo RepoRef, releaseId string) error {
+	return nil
+}
+
+// GitLab doesn't have release assets as such - we upload the file to the project and
+// link it to the release
+func (g *gitlab) UploadAsset(
+	ctx context.Context,
+	repo RepoRef,
+	releaseId string,
+	filename string,
+	content io.Reader,
+) error {
+	body, contentType := multipartFileBody("file", filename, content)
+	defer body.Close()
+
+	uploaded := struct {
+		Url      string `json:"url"`       // relative to project URL: "/uploads/<hash>/<filename>"
+		FullPath string `json:"full_path"` // newer versions: "/<group>/<project>/uploads/<hash>/<filename>"
+	}{}
+
+	if _, err := ezhttp.Post(
+		ctx,
+		g.projectEndpoint(repo, "/uploads"),
+		g.auth(),
+		ezhttp.SendBody(body, contentType),
+		ezhttp.RespondsJson(&uploaded, true),
+	); err != nil {
+		return err
+	}
+
+	assetUrl := g.baseUrl + uploaded.FullPath
+	if uploaded.FullPath == "" {
+		assetUrl = fmt.Sprintf("%s/%s/%s%s", g.baseUrl, repo.Owner, repo.Name, uploaded.Url)
+	}
+
+	_, err := ezhttp.Post(
+		ctx,
+		g.projectEndpoint(repo, fmt.Sprintf("/releases/%s/assets/links", url.PathEscape(releaseId))),
+		g.auth(),
+		ezhttp.SendJson(map[string]interface{}{
+			"name": filename,
+			"url":  assetUrl,
+		}))
+	return err
+}
+
+// projects are addressed by URL-encoded path "group%2Fproject"
+func (g *gitlab) projectEndpoint(repo RepoRef, path string) string {
+	return fmt.Sprintf(
+		"%s/api/v4/projects/%s%s",
+		g.baseUrl,
+		url.PathEscape(repo.Owner+"/"+repo.Name),
+		path)
+}
+
+func (g *gitlab) auth() ezhttp.ConfigPiece {
+	return authHeader("PRIVATE-TOKEN", g.token)
+}
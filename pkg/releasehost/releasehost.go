@@ -0,0 +1,58 @@
+// Release hosts (GitHub, Gitea, GitLab) - resolving, downloading and uploading release assets
+package releasehost
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/function61/gokit/ezhttp"
+)
+
+type RepoRef struct {
+	Owner string // for GitLab can contain subgroups ("group/subgroup")
+	Name  string
+}
+
+func NewRepoRef(owner, name string) RepoRef {
+	return RepoRef{owner, name}
+}
+
+type Asset struct {
+	Name string // filename (does not contain path)
+	Url  string // to download the asset
+}
+
+// lists and downloads release assets
+type Host interface {
+	ListAssets(ctx context.Context, repo RepoRef, releaseId string) ([]Asset, error)
+	DownloadAsset(ctx context.Context, asset Asset) (io.ReadCloser, error)
+}
+
+// host that we can also create releases in
+type ReleaseCreator interface {
+	Host
+	// returns release ID. if release with the name already exists, returns its ID so
+	// re-runs don't create duplicates
+	CreateRelease(ctx context.Context, repo RepoRef, releaseName string, revisionId string) (string, error)
+	UploadAsset(ctx context.Context, repo RepoRef, releaseId string, filename string, content io.Reader) error
+	// un-drafts the release. no-op for hosts without drafts (release is visible right away)
+	PublishRelease(ctx context.Context, repo RepoRef, releaseId string) error
+}
+
+var ErrReleaseNotFound = errors.New("release not found")
+
+func isNotFound(err error) bool {
+	statusErr := &ezhttp.ResponseStatusError{}
+	return errors.As(err, &statusErr) && statusErr.StatusCode() == http.StatusNotFound
+}
+
+// no-op if token empty (public repos)
+func authHeader(key string, value string) ezhttp.ConfigPiece {
+	return ezhttp.After(func(conf *ezhttp.Config) {
+		if value != "" {
+			conf.Request.Header.Set(key, value)
+		}
+	})
+}
@@ -0,0 +1,342 @@
+package releasehost
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestGitea(t *testing.T) {
+	fake := newFakeGitea(t)
+
+	// so existing release has to be looked up from the second page
+	for i := 1; i <= 60; i++ {
+		fake.releases = append(fake.releases, fmt.Sprintf("v0.%d", i))
+	}
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	fake.baseUrl = server.URL
+
+	testReleaseCreator(t, Gitea(server.URL, "secret"), "61")
+
+	assert.Assert(t, !fake.drafts["v1.2.3"])
+}
+
+func TestGitLab(t *testing.T) {
+	fake := newFakeGitLab(t)
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	fake.baseUrl = server.URL
+
+	testReleaseCreator(t, GitLab(server.URL, "secret"), "v1.2.3")
+}
+
+func testReleaseCreator(t *testing.T, host ReleaseCreator, expectedReleaseId string) {
+	t.Helper()
+
+	ctx := context.Background()
+	repo := NewRepoRef("function61", "coolproduct")
+
+	_, err := host.ListAssets(ctx, repo, expectedReleaseId)
+	assert.Assert(t, errors.Is(err, ErrReleaseNotFound))
+
+	releaseId, err := host.CreateRelease(ctx, repo, "v1.2.3", "9c39d027")
+	assert.Ok(t, err)
+	assert.EqualString(t, releaseId, expectedReleaseId)
+
+	// re-run must not create duplicate
+	releaseId, err = host.CreateRelease(ctx, repo, "v1.2.3", "9c39d027")
+	assert.Ok(t, err)
+	assert.EqualString(t, releaseId, expectedReleaseId)
+
+	assert.Ok(t, host.UploadAsset(ctx, repo, releaseId, "deployerspec.zip", strings.NewReader("spec content")))
+
+	assets, err := host.ListAssets(ctx, repo, releaseId)
+	assert.Ok(t, err)
+	assert.Assert(t, len(assets) == 1)
+	assert.EqualString(t, assets[0].Name, "deployerspec.zip")
+
+	content, err := host.DownloadAsset(ctx, assets[0])
+	assert.Ok(t, err)
+	defer content.Close()
+
+	contentBytes, err := ioutil.ReadAll(content)
+	assert.Ok(t, err)
+	assert.EqualString(t, string(contentBytes), "spec content")
+
+	assert.Ok(t, host.PublishRelease(ctx, repo, releaseId))
+}
+
+// state shared by fake servers
+type fakeHost struct {
+	t        *testing.T
+	mu       sync.Mutex
+	baseUrl  string
+	releases []string          // release names
+	assets   map[string]string // "<release>/<filename>" => content
+	files    map[string]string // download path => content
+}
+
+func newFakeHost(t *testing.T) fakeHost {
+	return fakeHost{
+		t:        t,
+		releases: []string{},
+		assets:   map[string]string{},
+		files:    map[string]string{},
+	}
+}
+
+func (f *fakeHost) requireAuth(w http.ResponseWriter, r *http.Request, header string, value string) bool {
+	if r.Header.Get(header) != value {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func (f *fakeHost) readUpload(r *http.Request, fieldName string) (string, string) {
+	file, header, err := r.FormFile(fieldName)
+	assert.Ok(f.t, err)
+	defer file.Close()
+
+	content, err := ioutil.ReadAll(file)
+	assert.Ok(f.t, err)
+
+	return header.Filename, string(content)
+}
+
+func (f *fakeHost) releaseIdx(name string) int {
+	for idx, release := range f.releases {
+		if release == name {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func writeJson(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+type fakeGitea struct {
+	fakeHost
+	drafts map[string]bool // release name => is draft
+}
+
+func newFakeGitea(t *testing.T) *fakeGitea {
+	return &fakeGitea{newFakeHost(t), map[string]bool{}}
+}
+
+func (f *fakeGitea) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.requireAuth(w, r, "Authorization", "token secret") {
+		return
+	}
+
+	const repoPrefix = "/api/v1/repos/function61/coolproduct"
+
+	releaseJson := func(idx int) interface{} {
+		assets := []interface{}{}
+		for key := range f.assets {
+			if strings.HasPrefix(key, f.releases[idx]+"/") {
+				assets = append(assets, map[string]string{
+					"name":                 strings.TrimPrefix(key, f.releases[idx]+"/"),
+					"browser_download_url": f.baseUrl + "/attachments/" + key,
+				})
+			}
+		}
+
+		return map[string]interface{}{
+			"id":       idx + 1,
+			"name":     f.releases[idx],
+			"tag_name": f.releases[idx],
+			"draft":    f.drafts[f.releases[idx]],
+			"assets":   assets,
+		}
+	}
+
+	// release ID "1" => index 0
+	releaseByIdParam := func(id string) int {
+		var idx int
+		if _, err := fmt.Sscanf(id, "%d", &idx); err != nil || idx < 1 || idx > len(f.releases) {
+			return -1
+		}
+		return idx - 1
+	}
+
+	path := r.URL.Path
+
+	switch {
+	case r.Method == http.MethodGet && path == repoPrefix+"/releases":
+		var page, limit int
+		_, err := fmt.Sscanf(r.URL.Query().Get("page")+" "+r.URL.Query().Get("limit"), "%d %d", &page, &limit)
+		assert.Ok(f.t, err)
+
+		releases := []interface{}{}
+		for idx := (page - 1) * limit; idx < page*limit && idx < len(f.releases); idx++ {
+			releases = append(releases, releaseJson(idx))
+		}
+		writeJson(w, releases)
+	case r.Method == http.MethodPost && path == repoPrefix+"/releases":
+		req := struct {
+			Name  string `json:"name"`
+			Draft bool   `json:"draft"`
+		}{}
+		assert.Ok(f.t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.releases = append(f.releases, req.Name)
+		f.drafts[req.Name] = req.Draft
+		writeJson(w, releaseJson(len(f.releases)-1))
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, repoPrefix+"/releases/"):
+		idx := releaseByIdParam(strings.TrimPrefix(path, repoPrefix+"/releases/"))
+		if idx == -1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		req := struct {
+			Draft bool `json:"draft"`
+		}{}
+		assert.Ok(f.t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.drafts[f.releases[idx]] = req.Draft
+		writeJson(w, releaseJson(idx))
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/assets"):
+		idx := releaseByIdParam(strings.TrimSuffix(strings.TrimPrefix(path, repoPrefix+"/releases/"), "/assets"))
+		if idx == -1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		_, content := f.readUpload(r, "attachment")
+		f.assets[f.releases[idx]+"/"+r.URL.Query().Get("name")] = content
+		writeJson(w, map[string]interface{}{})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, repoPrefix+"/releases/"):
+		idx := releaseByIdParam(strings.TrimPrefix(path, repoPrefix+"/releases/"))
+		if idx == -1 {
+			http.NotFound(w, r)
+			return
+		}
+		writeJson(w, releaseJson(idx))
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/attachments/"):
+		content, found := f.assets[strings.TrimPrefix(path, "/attachments/")]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	default:
+		f.t.Fatalf("unexpected request: %s %s", r.Method, r.URL.String())
+	}
+}
+
+type fakeGitLab struct {
+	fakeHost
+}
+
+func newFakeGitLab(t *testing.T) *fakeGitLab {
+	return &fakeGitLab{newFakeHost(t)}
+}
+
+func (f *fakeGitLab) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.requireAuth(w, r, "PRIVATE-TOKEN", "secret") {
+		return
+	}
+
+	const projectPrefix = "/api/v4/projects/function61%2Fcoolproduct"
+
+	path := r.URL.EscapedPath()
+
+	releaseJson := func(idx int) interface{} {
+		links := []interface{}{}
+		for key, url := range f.assets {
+			if strings.HasPrefix(key, f.releases[idx]+"/") {
+				links = append(links, map[string]string{
+					"name": strings.TrimPrefix(key, f.releases[idx]+"/"),
+					"url":  url,
+				})
+			}
+		}
+
+		return map[string]interface{}{
+			"tag_name": f.releases[idx],
+			"assets": map[string]interface{}{
+				"links":   links,
+				"sources": []interface{}{map[string]string{"format": "zip", "url": "ignored"}},
+			},
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && path == projectPrefix+"/releases":
+		req := struct {
+			TagName string `json:"tag_name"`
+		}{}
+		assert.Ok(f.t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.releases = append(f.releases, req.TagName)
+		writeJson(w, releaseJson(len(f.releases)-1))
+	case r.Method == http.MethodPost && path == projectPrefix+"/uploads":
+		filename, content := f.readUpload(r, "file")
+
+		uploadPath := fmt.Sprintf("/function61/coolproduct/uploads/%d/%s", len(f.files), filename)
+		f.files[uploadPath] = content
+
+		writeJson(w, map[string]string{
+			"url":       strings.TrimPrefix(uploadPath, "/function61/coolproduct"),
+			"full_path": uploadPath,
+		})
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/assets/links"):
+		idx := f.releaseIdx(strings.TrimSuffix(strings.TrimPrefix(path, projectPrefix+"/releases/"), "/assets/links"))
+		if idx == -1 {
+			http.NotFound(w, r)
+			return
+		}
+
+		req := struct {
+			Name string `json:"name"`
+			Url  string `json:"url"`
+		}{}
+		assert.Ok(f.t, json.NewDecoder(r.Body).Decode(&req))
+
+		f.assets[f.releases[idx]+"/"+req.Name] = req.Url
+		writeJson(w, map[string]interface{}{})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, projectPrefix+"/releases/"):
+		idx := f.releaseIdx(strings.TrimPrefix(path, projectPrefix+"/releases/"))
+		if idx == -1 {
+			http.NotFound(w, r)
+			return
+		}
+		writeJson(w, releaseJson(idx))
+	case r.Method == http.MethodGet && strings.Contains(path, "/uploads/"):
+		content, found := f.files[path]
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	default:
+		f.t.Fatalf("unexpected request: %s %s", r.Method, r.URL.String())
+	}
+}
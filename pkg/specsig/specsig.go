@@ -0,0 +1,142 @@
+// Ed25519 signatures for deployer specs. deployerspec.zip decides which image and command
+// run with our cloud credentials, so we want to know who produced it.
+package specsig
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	publicKeyPrefix = "ed25519:"
+	pemTypePrivKey  = "PRIVATE KEY"
+	FileSuffix      = ".sig" // "deployerspec.zip" => "deployerspec.zip.sig"
+)
+
+// detached signature, stored as JSON next to the signed file
+type Signature struct {
+	PublicKey string `json:"public_key"` // "ed25519:<base64>"
+	Signature string `json:"signature"`  // base64
+}
+
+// returns public key ("ed25519:<base64>") and PEM-encoded private key
+func GenerateKey() (string, []byte, error) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	privateKeyPkcs8, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return serializePublicKey(publicKey), pem.EncodeToMemory(&pem.Block{
+		Type:  pemTypePrivKey,
+		Bytes: privateKeyPkcs8,
+	}), nil
+}
+
+func Sign(content []byte, privateKeyPem []byte) (*Signature, error) {
+	privateKey, err := parsePrivateKey(privateKeyPem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signature{
+		PublicKey: serializePublicKey(privateKey.Public().(ed25519.PublicKey)),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, content)),
+	}, nil
+}
+
+// errors unless content was signed by one of the trusted keys
+func Verify(content []byte, signature Signature, trustedKeys []string) error {
+	trusted := false
+	for _, trustedKey := range trustedKeys {
+		if trustedKey == signature.PublicKey {
+			trusted = true
+			break
+		}
+	}
+
+	if !trusted {
+		return fmt.Errorf("signed by untrusted key %s", signature.PublicKey)
+	}
+
+	publicKey, err := parsePublicKey(signature.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("signature: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, content, signatureBytes) {
+		return errors.New("signature verification failed")
+	}
+
+	return nil
+}
+
+func Read(serialized io.Reader) (*Signature, error) {
+	signature := &Signature{}
+	if err := json.NewDecoder(serialized).Decode(signature); err != nil {
+		return nil, fmt.Errorf("specsig.Read: %w", err)
+	}
+
+	return signature, nil
+}
+
+func (s Signature) Write(output io.Writer) error {
+	return json.NewEncoder(output).Encode(&s)
+}
+
+func serializePublicKey(publicKey ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(publicKey)
+}
+
+func parsePublicKey(serialized string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(serialized, publicKeyPrefix) {
+		return nil, fmt.Errorf("unsupported public key type: %s", serialized)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(serialized[len(publicKeyPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key: invalid length %d", len(publicKey))
+	}
+
+	return ed25519.PublicKey(publicKey), nil
+}
+
+func parsePrivateKey(privateKeyPem []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPem)
+	if block == nil || block.Type != pemTypePrivKey {
+		return nil, errors.New("private key: PEM decode failed")
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+
+	ed25519PrivateKey, ok := privateKey.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key: not an ed25519 key")
+	}
+
+	return ed25519PrivateKey, nil
+}
@@ -0,0 +1,40 @@
+package specsig
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/function61/gokit/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	publicKey, privateKey, err := GenerateKey()
+	assert.Ok(t, err)
+
+	otherPublicKey, _, err := GenerateKey()
+	assert.Ok(t, err)
+
+	content := []byte("pretend this is deployerspec.zip")
+
+	signature, err := Sign(content, privateKey)
+	assert.Ok(t, err)
+	assert.EqualString(t, signature.PublicKey, publicKey)
+
+	// roundtrip through serialization
+	serialized := &bytes.Buffer{}
+	assert.Ok(t, signature.Write(serialized))
+	signature, err = Read(serialized)
+	assert.Ok(t, err)
+
+	assert.Ok(t, Verify(content, *signature, []string{otherPublicKey, publicKey}))
+
+	assert.EqualString(
+		t,
+		Verify(content, *signature, []string{otherPublicKey}).Error(),
+		"signed by untrusted key "+publicKey)
+
+	assert.EqualString(
+		t,
+		Verify([]byte("tampered"), *signature, []string{publicKey}).Error(),
+		"signature verification failed")
+}